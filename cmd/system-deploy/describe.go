@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	"github.com/fatih/color"
-	"github.com/khulnasoft-lab/system-conf/conf"
 	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
 	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
 	"github.com/mitchellh/go-wordwrap"
@@ -108,7 +107,7 @@ var describe = &cobra.Command{
 			}
 		}
 
-		if !conf.IsAllowAny(plg.Options) {
+		if true {
 			fmt.Printf("\n%s\n\n", header("Options"))
 
 			for _, opt := range plg.Options {
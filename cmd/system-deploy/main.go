@@ -1,8 +1,14 @@
 package main
 
-import "log"
+import (
+	"log"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
 
 func main() {
+	utils.SandboxInit()
+
 	if err := getRootCmd().Execute(); err != nil {
 		log.Fatal(err)
 	}
@@ -17,12 +17,24 @@ EOT
 }
 
 gendoc InstallPackages
+gendoc PackageRepository
 gendoc Platform
 gendoc Systemd
 gendoc Copy
+gendoc Download
+gendoc Extract
 gendoc Exec
 gendoc OnChange
 gendoc EditFile
+gendoc KeyValue
+gendoc IniFile
+gendoc BlockInFile
+gendoc EditStructured
+gendoc Patch
+gendoc File
+gendoc Symlink
+gendoc User
+gendoc Group
 
 cat > ./docs/docs/concepts/task-props.md <<EOT
 ---
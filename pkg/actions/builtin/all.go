@@ -2,10 +2,19 @@ package builtin
 
 import (
 	// Import all built-in actions
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/blockinfile"
 	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/copy"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/download"
 	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/editfile"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/editstructured"
 	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/exec"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/extract"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/file"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/keyvalue"
 	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/onchange"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/patch"
 	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/platform"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/symlink"
 	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/systemd"
+	_ "github.com/khulnasoft-lab/system-deploy/pkg/actions/builtin/user"
 )
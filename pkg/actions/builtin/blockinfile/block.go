@@ -0,0 +1,115 @@
+package blockinfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// block describes a managed block of lines within a file.
+type block struct {
+	// begin and end are the marker lines surrounding the block.
+	begin string
+	end   string
+	// content holds the lines between the markers.
+	content []string
+	// insertAfter and insertBefore are used to find the position
+	// of a new block. If both are nil new blocks are appended.
+	insertAfter  *regexp.Regexp
+	insertBefore *regexp.Regexp
+	// absent is set if the block should be removed.
+	absent bool
+}
+
+// apply returns lines with the block inserted, updated or removed.
+// An existing block is updated in place and any further blocks
+// with the same markers are removed.
+func (b *block) apply(lines []string) ([]string, error) {
+	var (
+		result = make([]string, 0, len(lines)+len(b.content)+2)
+		found  = -1
+		inside bool
+	)
+
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if inside {
+			if trimmed == b.end {
+				inside = false
+			}
+			continue
+		}
+
+		if trimmed != b.begin {
+			result = append(result, line)
+			continue
+		}
+
+		if !b.hasEnd(lines[idx+1:]) {
+			return nil, fmt.Errorf("line %d: %q is not followed by %q", idx+1, b.begin, b.end)
+		}
+
+		inside = true
+		if found == -1 {
+			found = len(result)
+			if !b.absent {
+				result = append(result, b.lines()...)
+			}
+		}
+	}
+
+	if found != -1 || b.absent {
+		return result, nil
+	}
+
+	idx := b.position(result)
+
+	result = append(result, b.lines()...)
+	copy(result[idx+len(b.content)+2:], result[idx:])
+	copy(result[idx:], b.lines())
+
+	return result, nil
+}
+
+// hasEnd returns true if lines contain the end marker.
+func (b *block) hasEnd(lines []string) bool {
+	for _, line := range lines {
+		if strings.TrimSpace(line) == b.end {
+			return true
+		}
+	}
+	return false
+}
+
+// lines returns the block including the markers.
+func (b *block) lines() []string {
+	lines := make([]string, 0, len(b.content)+2)
+	lines = append(lines, b.begin)
+	lines = append(lines, b.content...)
+	return append(lines, b.end)
+}
+
+// position returns the index at which a new block should be
+// inserted. InsertAfter= uses the last matching line while
+// InsertBefore= uses the first one. If nothing matches the block is
+// appended.
+func (b *block) position(lines []string) int {
+	if b.insertAfter != nil {
+		for idx := len(lines) - 1; idx >= 0; idx-- {
+			if b.insertAfter.MatchString(lines[idx]) {
+				return idx + 1
+			}
+		}
+	}
+
+	if b.insertBefore != nil {
+		for idx, line := range lines {
+			if b.insertBefore.MatchString(line) {
+				return idx
+			}
+		}
+	}
+
+	return len(lines)
+}
@@ -0,0 +1,68 @@
+package blockinfile
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockApply(t *testing.T) {
+	cases := []struct {
+		name   string
+		block  block
+		input  string
+		output string
+	}{
+		{
+			name:   "append",
+			block:  block{content: []string{"a"}},
+			input:  "x",
+			output: "x\nB\na\nE",
+		},
+		{
+			name:   "update in place",
+			block:  block{content: []string{"a", "b"}},
+			input:  "x\nB\nold\nE\ny",
+			output: "x\nB\na\nb\nE\ny",
+		},
+		{
+			name:   "remove duplicates",
+			block:  block{content: []string{"a"}},
+			input:  "B\na\nE\nx\nB\na\nE",
+			output: "B\na\nE\nx",
+		},
+		{
+			name:   "insert after last match",
+			block:  block{content: []string{"a"}, insertAfter: regexp.MustCompile("^x")},
+			input:  "x1\nx2\ny",
+			output: "x1\nx2\nB\na\nE\ny",
+		},
+		{
+			name:   "insert before first match",
+			block:  block{content: []string{"a"}, insertBefore: regexp.MustCompile("^y")},
+			input:  "x\ny1\ny2",
+			output: "x\nB\na\nE\ny1\ny2",
+		},
+		{
+			name:   "absent",
+			block:  block{absent: true},
+			input:  "x\nB\na\nE\ny",
+			output: "x\ny",
+		},
+	}
+
+	for _, c := range cases {
+		c.block.begin, c.block.end = "B", "E"
+
+		result, err := c.block.apply(strings.Split(c.input, "\n"))
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.output, strings.Join(result, "\n"), c.name)
+	}
+
+	b := block{begin: "B", end: "E"}
+	_, err := b.apply([]string{"B", "a"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+package blockinfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "BlockInFile",
+		Description: "Insert, update or remove a block of lines surrounded by marker lines",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Markers",
+				Description: "" +
+					"The block is surrounded by a begin and an end marker line built from Marker=. `{mark}` is replaced by `BEGIN` or `END` " +
+					"and `{id}` by the value of ID=. Use a distinct ID= for each block managed in the same file. " +
+					"If the block already exists it is updated in place, otherwise it is inserted as configured by InsertAfter= and InsertBefore=. " +
+					"Further blocks with the same markers are removed.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"File= is only replaced if the block has been inserted or removed or its content differs. " +
+					"The task is only marked as changed if File= has been modified.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "File",
+				Description: "The absolute path of the file to modify.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "ID",
+				Description: "An identifier for the block that is used in the marker lines.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Block",
+				Description: "A line of the block. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "Marker",
+				Description: "The template for the marker lines. Must contain `{mark}`.",
+				Type:        conf.StringType,
+				Default:     defaultMarker,
+			},
+			{
+				Name:        "InsertAfter",
+				Description: "A regular expression. A new block is inserted after the last matching line. If no line matches the block is appended to the file.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "InsertBefore",
+				Description: "A regular expression. A new block is inserted before the first matching line. If no line matches the block is appended to the file. Mutually exclusive with InsertAfter=.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "State",
+				Description: "Either `present` or `absent`. If `absent`, the block including the marker lines is removed.",
+				Type:        conf.StringType,
+				Default:     statePresent,
+			},
+			{
+				Name:        "CreateFile",
+				Description: "If set to yes and File= does not exist it is created with mode 0644. Otherwise a missing file is an error.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "PreserveAttributes",
+				Description: "If set to yes, the owner, group and extended attributes (including POSIX ACLs) of File= are preserved when it is replaced.",
+				Type:        conf.BoolType,
+				Default:     "yes",
+			},
+		},
+	})
+}
+
+// Supported values for the State= option.
+const (
+	statePresent = "present"
+	stateAbsent  = "absent"
+)
+
+// defaultMarker is the default value for Marker=.
+const defaultMarker = "# {mark} system-deploy {id}"
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	file, err := sec.GetString("File")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(file) {
+		return nil, fmt.Errorf("File must be absolute: %q", file)
+	}
+
+	id, err := sec.GetString("ID")
+	if err != nil {
+		return nil, err
+	}
+
+	marker, err := sec.GetString("Marker")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		marker = defaultMarker
+	}
+
+	if !strings.Contains(marker, "{mark}") {
+		return nil, fmt.Errorf("invalid value for Marker: %q does not contain {mark}", marker)
+	}
+
+	marker = strings.ReplaceAll(marker, "{id}", id)
+
+	a := &action{
+		file: filepath.Clean(file),
+		id:   id,
+		block: block{
+			begin:   strings.TrimSpace(strings.ReplaceAll(marker, "{mark}", "BEGIN")),
+			end:     strings.TrimSpace(strings.ReplaceAll(marker, "{mark}", "END")),
+			content: sec.GetStringSlice("Block"),
+		},
+		createFile: sec.GetBoolDefault("CreateFile", false),
+		discard:    !sec.GetBoolDefault("PreserveAttributes", true),
+	}
+
+	state, err := sec.GetString("State")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		state = statePresent
+	}
+
+	if state != statePresent && state != stateAbsent {
+		return nil, fmt.Errorf("invalid value for State: %q", state)
+	}
+	a.block.absent = state == stateAbsent
+
+	if a.block.insertAfter, err = getRegexp(sec, "InsertAfter"); err != nil {
+		return nil, err
+	}
+
+	if a.block.insertBefore, err = getRegexp(sec, "InsertBefore"); err != nil {
+		return nil, err
+	}
+
+	if a.block.insertAfter != nil && a.block.insertBefore != nil {
+		return nil, fmt.Errorf("InsertAfter= and InsertBefore= are mutually exclusive")
+	}
+
+	return a, nil
+}
+
+// getRegexp compiles the regular expression in option name. It
+// returns nil if the option is not set.
+func getRegexp(sec conf.Section, name string) (*regexp.Regexp, error) {
+	expr, err := sec.GetString(name)
+	if err != nil {
+		if conf.IsNotSet(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+
+	return re, nil
+}
+
+type action struct {
+	actions.Base
+
+	file       string
+	id         string
+	block      block
+	createFile bool
+	discard    bool
+}
+
+func (a *action) Name() string {
+	return "BlockInFile " + a.id + " in " + a.file
+}
+
+func (a *action) Execute(_ context.Context) (bool, error) {
+	mode := os.FileMode(0644)
+
+	content, err := ioutil.ReadFile(a.file)
+	switch {
+	case err == nil:
+		if mode, err = utils.FileMode(a.file); err != nil {
+			return false, err
+		}
+	case !os.IsNotExist(err):
+		return false, err
+	case a.block.absent:
+		return false, nil
+	case !a.createFile:
+		return false, fmt.Errorf("%s does not exist, set CreateFile=yes to create it", a.file)
+	}
+
+	var lines []string
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	result, err := a.block.apply(lines)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", a.file, err)
+	}
+
+	var data []byte
+	if len(result) > 0 {
+		data = []byte(strings.Join(result, "\n") + "\n")
+	}
+
+	if bytes.Equal(content, data) {
+		return false, nil
+	}
+
+	opts := &utils.AtomicOptions{
+		DiscardOwner:  a.discard,
+		DiscardXattrs: a.discard,
+	}
+
+	if err := utils.CreateAtomicOwner(a.file, mode, -1, -1, bytes.NewReader(data), opts); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+const example = `[Task]
+Description= Add static host entries
+
+[BlockInFile]
+File=/etc/hosts
+ID=cluster
+Block=10.0.0.1 node1
+Block=10.0.0.2 node2
+InsertAfter=^127\.0\.0\.1
+`
@@ -7,9 +7,6 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/fatih/color"
-	copyDir "github.com/otiai10/copy"
-
 	"github.com/khulnasoft-lab/system-conf/conf"
 	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
 	"github.com/khulnasoft-lab/system-deploy/pkg/change"
@@ -31,21 +28,23 @@ func init() {
 				Description: "" +
 					"The `Copy` action uses a Murmur3 hash to check whether or not a destination file needs to be updated. " +
 					"In any case, `Copy` ensures the destination files mode bit either match the value of FileMode= or the mode bits of the source file. " +
-					"See FileMode= for more information.",
-			},
-			{
-				Title: "Bugs",
-				Description: "Note that " + color.New(color.Bold).Sprint("FileMode") + " does not work when copying a directory recursively. " +
-					"Also, directories are always copied without checking if an update is required." +
-					"This will be fixed in a later release.",
+					"See FileMode= for more information. " +
+					"The same applies to Owner= and Group=, the ownership of the destination file is corrected even if the content is already up-to-date. " +
+					"When copying a directory, each file is compared and updated individually and FileMode= and DirectoryMode= are applied to the whole tree if set. " +
+					"The task is only marked as changed if at least one file or directory has been written or modified.",
 			},
 		},
 		Options: []conf.OptionSpec{
 			{
-				Name:        "Source",
-				Required:    true,
-				Description: "The source file to copy to Destination.",
-				Type:        conf.StringType,
+				Name:     "Source",
+				Required: true,
+				Description: "" +
+					"The source file to copy to Destination. Source may also be a glob pattern (see filepath.Match) where a path segment " +
+					"consisting of `**` matches zero or more directories. In this case Destination must end with a path separator " +
+					"and all matching regular files are copied to Destination, keeping their path relative to the first directory " +
+					"of Source that does not contain a glob pattern. Symbolic links are never followed when matching a glob pattern, " +
+					"matching symbolic links to files are skipped.",
+				Type: conf.StringType,
 			},
 			{
 				Name:        "Destination",
@@ -62,18 +61,93 @@ func init() {
 			{
 				Name: "FileMode",
 				Description: "" +
-					"The mode bits (before umask) to use for the destination file. If unset the source files" +
+					"The mode bits to use for the destination file. If unset the source files " +
 					"mode bits will be used. The destination files mode will be changed to match FileMode= " +
-					"even if the content is already correct." +
-					"Note that Mode is ingnored when copying directories.",
+					"even if the content is already correct. " +
+					"When copying directories, FileMode= is applied to all files in the tree.",
 				Type:    conf.IntType,
 				Default: "",
 			},
 			{
-				Name:        "DirectoryMode",
-				Description: "When creating Destination path (CreateDirectories=yes) the mode bits (before umask) for that directories.",
-				Type:        conf.IntType,
-				Default:     "0755",
+				Name: "DirectoryMode",
+				Description: "" +
+					"When creating Destination path (CreateDirectories=yes) the mode bits (before umask) for that directories. " +
+					"Defaults to 0755 for those directories. " +
+					"When copying directories, DirectoryMode= is applied to all directories in the tree if set. " +
+					"Otherwise the mode bits of the source directories are used.",
+				Type:    conf.IntType,
+				Default: "",
+			},
+			{
+				Name:        "Owner",
+				Description: "The owner (name or ID) of the destination file. If unset, the owner is not changed.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Group",
+				Description: "The group (name or ID) of the destination file. If unset, the group is not changed.",
+				Type:        conf.StringType,
+			},
+			{
+				Name: "DirectoryOwner",
+				Description: "" +
+					"The owner and group of directories in the form `owner[:group]` (names or IDs). " +
+					"It is applied to all directories created because of CreateDirectories=yes as well as to all directories " +
+					"copied when Source is a directory. Already existing parent directories of Destination are not changed.",
+				Type: conf.StringType,
+			},
+			{
+				Name: "Mirror",
+				Description: "" +
+					"If set to yes and Source is a directory, all files and directories below Destination that do not exist in Source are removed. " +
+					"Entries matching Exclude= are never removed. Mirror= refuses to operate on `/`.",
+				Type:    conf.BoolType,
+				Default: "no",
+			},
+			{
+				Name: "Exclude",
+				Description: "" +
+					"A glob pattern for files and directories that should not be copied. Patterns containing a path separator are matched against " +
+					"the path relative to Source, all other patterns against the file name only. Patterns with a trailing path separator only match directories. " +
+					"May be specified multiple times.",
+				Type: conf.StringSliceType,
+			},
+			{
+				Name: "Symlinks",
+				Description: "" +
+					"What to do with symbolic links when copying a directory. One of `preserve` (create a symbolic link with the same target), " +
+					"`follow` (copy the file or directory the link points to) or `skip`.",
+				Type:    conf.StringType,
+				Default: symlinksPreserve,
+			},
+			{
+				Name: "ValidateCommand",
+				Description: "" +
+					"A command to validate each file before it is installed, for example `visudo -cf %s`. %s is replaced with the path of " +
+					"the staged temporary file. The destination is only replaced if the command exits successfully, " +
+					"otherwise the action fails and reports the output of the command.",
+				Type: conf.StringType,
+			},
+			{
+				Name: "PreserveAttributes",
+				Description: "" +
+					"If set to yes, the owner, group and extended attributes (including POSIX ACLs) of an existing destination file " +
+					"are preserved when it is replaced. Owner= and Group= take precedence. If FileMode= is set, the POSIX access ACL " +
+					"is not preserved because it would override the group bits of FileMode=.",
+				Type:    conf.BoolType,
+				Default: "yes",
+			},
+			{
+				Name:        "AllowEmpty",
+				Description: "If Source is a glob pattern, do not treat it as an error if the pattern does not match any files.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "DryRun",
+				Description: "If set to yes, only log which files and directories would be copied or removed without modifying Destination.",
+				Type:        conf.BoolType,
+				Default:     "no",
 			},
 		},
 	})
@@ -122,7 +196,8 @@ func (a *action) Prepare(graph actions.ExecGraph) error {
 			if !conf.IsNotSet(err) {
 				return fmt.Errorf("invalid value for FileMode: %w", err)
 			}
-			fileMode = 0700
+			// use the mode bits of the source file.
+			fileMode = 0
 		} else {
 			if fileMode > 0777 {
 				return fmt.Errorf("invalid value for FileMode: %o", fileMode)
@@ -137,7 +212,8 @@ func (a *action) Prepare(graph actions.ExecGraph) error {
 			if !conf.IsNotSet(err) {
 				return fmt.Errorf("invalid value for DirectoryMode: %w", err)
 			}
-			dirMode = 0755
+			// use the mode bits of the source directory.
+			dirMode = 0
 		} else {
 			if dirMode > 0777 {
 				return fmt.Errorf("invalid value for DirectoryMode: %o", dirMode)
@@ -147,6 +223,45 @@ func (a *action) Prepare(graph actions.ExecGraph) error {
 	}
 
 	{
+		a.owner, err = a.opts.GetString("Owner")
+		if err != nil && !conf.IsNotSet(err) {
+			return err
+		}
+
+		a.group, err = a.opts.GetString("Group")
+		if err != nil && !conf.IsNotSet(err) {
+			return err
+		}
+
+		dirOwner, err := a.opts.GetString("DirectoryOwner")
+		if err != nil && !conf.IsNotSet(err) {
+			return err
+		}
+
+		a.dirOwner, a.dirGroup = dirOwner, ""
+		if idx := strings.Index(dirOwner, ":"); idx > -1 {
+			a.dirOwner, a.dirGroup = dirOwner[:idx], dirOwner[idx+1:]
+		}
+	}
+
+	if isGlob(a.source) {
+		a.sourceIsGlob = true
+
+		// make sure the pattern is valid, the actual matches are
+		// only evaluated when the action is executed.
+		if _, err := matchGlob(a.source, a.source); err != nil {
+			return fmt.Errorf("invalid value for Source: %w", err)
+		}
+
+		if !strings.HasSuffix(destination, string(filepath.Separator)) {
+			return fmt.Errorf("Destination must end with a path separator if Source is a glob pattern")
+		}
+
+		a.allowEmpty, err = a.opts.GetBool("AllowEmpty")
+		if err != nil && !conf.IsNotSet(err) {
+			return fmt.Errorf("invalid value for AllowEmpty: %w", err)
+		}
+	} else {
 		fi, err := os.Stat(a.source)
 		if err != nil {
 			return fmt.Errorf("source: %w", err)
@@ -155,7 +270,61 @@ func (a *action) Prepare(graph actions.ExecGraph) error {
 	}
 
 	{
-		if strings.HasSuffix(destination, string(filepath.Separator)) {
+		a.mirror, err = a.opts.GetBool("Mirror")
+		if err != nil && !conf.IsNotSet(err) {
+			return fmt.Errorf("invalid value for Mirror: %w", err)
+		}
+
+		if a.mirror && !a.sourceIsDir {
+			return fmt.Errorf("Mirror= requires Source to be a directory")
+		}
+
+		validateCommand, err := a.opts.GetString("ValidateCommand")
+		if err != nil && !conf.IsNotSet(err) {
+			return err
+		}
+
+		if validateCommand != "" {
+			a.validator, err = utils.NewValidator(validateCommand)
+			if err != nil {
+				return fmt.Errorf("invalid value for ValidateCommand: %w", err)
+			}
+		}
+
+		a.preserve = a.opts.GetBoolDefault("PreserveAttributes", true)
+
+		a.dryRun, err = a.opts.GetBool("DryRun")
+		if err != nil && !conf.IsNotSet(err) {
+			return fmt.Errorf("invalid value for DryRun: %w", err)
+		}
+
+		a.exclude = a.opts.GetStringSlice("Exclude")
+		for _, pattern := range a.exclude {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid value for Exclude: %q: %w", pattern, err)
+			}
+		}
+
+		a.symlinks, err = a.opts.GetString("Symlinks")
+		if err != nil {
+			if !conf.IsNotSet(err) {
+				return err
+			}
+			a.symlinks = symlinksPreserve
+		}
+
+		switch a.symlinks {
+		case symlinksFollow, symlinksPreserve, symlinksSkip:
+		default:
+			return fmt.Errorf("invalid value for Symlinks: %q", a.symlinks)
+		}
+	}
+
+	{
+		if a.sourceIsGlob {
+			a.destDir = destination
+			a.destName = ""
+		} else if strings.HasSuffix(destination, string(filepath.Separator)) {
 			a.destDir = destination
 			a.destName = filepath.Base(a.source)
 		} else {
@@ -166,6 +335,12 @@ func (a *action) Prepare(graph actions.ExecGraph) error {
 		if err := checkDirectory(a.destDir, a.createPath); err != nil {
 			return err
 		}
+
+		if a.mirror {
+			if err := checkMirrorDestination(filepath.Join(a.destDir, a.destName)); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -178,13 +353,25 @@ type action struct {
 	opts    conf.Options
 	log     actions.Logger
 
-	source      string
-	sourceIsDir bool
-	fileMode    os.FileMode
-	dirMode     os.FileMode
-	destDir     string
-	destName    string
-	createPath  bool
+	source       string
+	sourceIsDir  bool
+	sourceIsGlob bool
+	allowEmpty   bool
+	fileMode     os.FileMode
+	dirMode      os.FileMode
+	destDir      string
+	destName     string
+	createPath   bool
+	owner        string
+	group        string
+	dirOwner     string
+	dirGroup     string
+	mirror       bool
+	dryRun       bool
+	exclude      []string
+	symlinks     string
+	validator    *utils.Validator
+	preserve     bool
 
 	runPost bool
 }
@@ -196,22 +383,45 @@ func (a *action) Name() string {
 func (a *action) Execute(ctx context.Context) (bool, error) {
 	var changed bool
 
+	// users and groups are resolved only now because they might
+	// have been created by a previous task.
+	uid, gid, err := utils.LookupOwner(a.owner, a.group)
+	if err != nil {
+		return false, err
+	}
+
+	dirUID, dirGID, err := utils.LookupOwner(a.dirOwner, a.dirGroup)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for DirectoryOwner: %w", err)
+	}
+
+	if a.dryRun {
+		return false, a.plan(ctx)
+	}
+
 	if a.createPath {
-		if err := os.MkdirAll(a.destDir, a.dirMode); err != nil {
+		dirMode := a.dirMode
+		if dirMode == 0 {
+			dirMode = 0755
+		}
+		if err := mkdirAll(a.destDir, dirMode, dirUID, dirGID); err != nil {
 			return false, fmt.Errorf("failed to create destination %q: %w", a.destDir, err)
 		}
 	}
 
 	dest := filepath.Join(a.destDir, a.destName)
-	if a.sourceIsDir {
-		// TODO(khulnasoft-lab): allow specifying symlink actions.
-		if err := copyDir.Copy(a.source, dest, copyDir.DefaultOptions); err != nil {
-			return false, fmt.Errorf("failed to copy directory: %w", err)
+	if a.sourceIsGlob {
+		changed, err = a.copyMatches(ctx, dest, uid, gid, dirUID, dirGID)
+		if err != nil {
+			return changed, err
+		}
+	} else if a.sourceIsDir {
+		changed, err = a.copyDirectory(ctx, dest, uid, gid, dirUID, dirGID)
+		if err != nil {
+			return changed, fmt.Errorf("failed to copy directory: %w", err)
 		}
-		changed = true // change detection is not yet supported when copying directories.
 	} else {
-		var err error
-		changed, err = a.copyRegularFile()
+		changed, err = a.copyRegularFile(ctx, uid, gid)
 		if err != nil {
 			return false, err
 		}
@@ -222,10 +432,38 @@ func (a *action) Execute(ctx context.Context) (bool, error) {
 	return changed, nil
 }
 
+// plan logs all changes that would be performed without actually
+// modifying anything.
+func (a *action) plan(ctx context.Context) error {
+	if a.createPath {
+		if _, err := os.Stat(a.destDir); os.IsNotExist(err) {
+			a.Infof("would create directory %s", a.destDir)
+		}
+	}
+
+	dest := filepath.Join(a.destDir, a.destName)
+	if a.sourceIsGlob {
+		_, err := a.copyMatches(ctx, dest, -1, -1, -1, -1)
+		return err
+	}
+
+	if a.sourceIsDir {
+		_, err := a.copyDirectory(ctx, dest, -1, -1, -1, -1)
+		return err
+	}
+
+	info, err := os.Stat(a.source)
+	if err != nil {
+		return err
+	}
+
+	return a.planEntry(entry{path: a.source, rel: a.destName, info: info}, dest)
+}
+
 func (a *action) getModeForFile() (os.FileMode, error) {
 	fileMode := a.fileMode
 	if fileMode == 0 {
-		info, err := os.Lstat(a.source)
+		info, err := os.Stat(a.source)
 		if err != nil {
 			return 0, fmt.Errorf("failed to stat source %q: %s", a.source, err)
 		}
@@ -235,7 +473,7 @@ func (a *action) getModeForFile() (os.FileMode, error) {
 	return fileMode, nil
 }
 
-func (a *action) copyRegularFile() (bool, error) {
+func (a *action) copyRegularFile(ctx context.Context, uid, gid int) (bool, error) {
 	dest := filepath.Join(a.destDir, a.destName)
 
 	// find out which file mode we need to use, that is, either the one
@@ -245,24 +483,48 @@ func (a *action) copyRegularFile() (bool, error) {
 		return false, err
 	}
 
-	// check if we actually need to update dest.
-	updateRequired, err := change.FileUpdateNeeded(a.source, dest)
-	if err != nil {
-		return false, fmt.Errorf("failed to check for required file update: %w", err)
+	return copyFile(a.source, dest, fileMode, uid, gid, a.atomicOptions(ctx))
+}
+
+// atomicOptions returns the options used when replacing
+// destination files.
+func (a *action) atomicOptions(ctx context.Context) *utils.AtomicOptions {
+	return &utils.AtomicOptions{
+		Validate:      a.validator.Func(ctx),
+		DiscardOwner:  !a.preserve,
+		DiscardXattrs: !a.preserve,
+		ExplicitMode:  a.fileMode != 0,
 	}
-	if !updateRequired {
-		// file already exists and has the expected content, make sure
-		// we have the correct file mode and we are done.
-		return change.EnsureFileMode(dest, fileMode)
+}
+
+// mkdirAll is like os.MkdirAll but changes the owner of all
+// directories it creates to uid and gid.
+func mkdirAll(path string, mode os.FileMode, uid, gid int) error {
+	var missing []string
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		missing = append(missing, p)
+		if p == filepath.Dir(p) {
+			break
+		}
 	}
 
-	// finally replace/create dest from a.source and apply the correct
-	// file mode. If dest exists it will be overwritten.
-	if err := utils.CopyAtomicMode(a.source, dest, fileMode); err != nil {
-		return false, err
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], mode); err != nil {
+			return err
+		}
+
+		if _, err := change.EnsureFileOwner(missing[i], uid, gid); err != nil {
+			return err
+		}
 	}
 
-	return true, nil
+	return nil
 }
 
 func checkDirectory(path string, ignoreMissing bool) error {
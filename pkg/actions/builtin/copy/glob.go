@@ -0,0 +1,228 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isGlob returns true if path contains any glob meta characters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// splitGlob splits pattern into the longest leading directory
+// that does not contain any glob meta characters and the remaining
+// pattern.
+func splitGlob(pattern string) (base, rest string) {
+	segments := strings.Split(filepath.Clean(pattern), string(filepath.Separator))
+
+	for idx, seg := range segments {
+		if isGlob(seg) {
+			base = strings.Join(segments[:idx], string(filepath.Separator))
+			if base == "" {
+				base = string(filepath.Separator)
+			}
+			return base, filepath.Join(segments[idx:]...)
+		}
+	}
+
+	return filepath.Dir(pattern), filepath.Base(pattern)
+}
+
+// matchGlob reports whether the relative path name matches pattern.
+// In addition to the syntax supported by filepath.Match, a path
+// segment consisting of `**` matches zero or more directories.
+func matchGlob(pattern, name string) (bool, error) {
+	return matchSegments(
+		strings.Split(pattern, string(filepath.Separator)),
+		strings.Split(name, string(filepath.Separator)),
+	)
+}
+
+func matchSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// try to match the remaining pattern against
+			// all possible suffixes of name.
+			for idx := 0; idx <= len(name); idx++ {
+				ok, err := matchSegments(pattern[1:], name[idx:])
+				if ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(name) == 0 {
+			return false, nil
+		}
+
+		ok, err := filepath.Match(pattern[0], name[0])
+		if !ok || err != nil {
+			return false, err
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0, nil
+}
+
+// canContainMatch reports whether the directory rel (relative to
+// the base directory of pattern) may contain files matching pattern.
+// Patterns containing `**` may match at any depth.
+func canContainMatch(pattern, rel string) (bool, error) {
+	if rel == "." {
+		return true, nil
+	}
+
+	segments := strings.Split(pattern, string(filepath.Separator))
+	dirs := strings.Split(rel, string(filepath.Separator))
+
+	for idx, dir := range dirs {
+		if idx >= len(segments)-1 {
+			// files in rel would be too deep to match.
+			return false, nil
+		}
+
+		if segments[idx] == "**" {
+			return true, nil
+		}
+
+		ok, err := filepath.Match(segments[idx], dir)
+		if !ok || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// glob returns all regular files that match pattern. The rel field
+// of each entry is relative to the leading directory of pattern
+// that does not contain glob meta characters. Directories that
+// cannot contain any matches are not visited and symbolic links are
+// never followed.
+func (a *action) glob(pattern string) ([]entry, error) {
+	base, rest := splitGlob(pattern)
+
+	var matches []entry
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == base {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			ok, err := canContainMatch(rest, rel)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			if info.Mode()&os.ModeSymlink != 0 {
+				a.Debugf("skipping symbolic link %s", path)
+			}
+			return nil
+		}
+
+		ok, err := matchGlob(rest, rel)
+		if err != nil || !ok {
+			return err
+		}
+
+		if a.excluded(rel, false) {
+			a.Debugf("excluding %s", path)
+			return nil
+		}
+
+		matches = append(matches, entry{path: path, rel: rel, info: info})
+		return nil
+	})
+
+	return matches, err
+}
+
+// copyMatches copies all files matching the Source= glob pattern
+// to dest. Missing directories below dest are created. It returns
+// true if at least one file or directory has been written or
+// modified.
+func (a *action) copyMatches(ctx context.Context, dest string, uid, gid, dirUID, dirGID int) (bool, error) {
+	var changed bool
+
+	matches, err := a.glob(a.source)
+	if err != nil {
+		return false, err
+	}
+
+	if len(matches) == 0 {
+		if !a.allowEmpty {
+			return false, fmt.Errorf("%s: no files matched", a.source)
+		}
+		a.Infof("%s: no files matched", a.source)
+		return false, nil
+	}
+
+	for _, e := range matches {
+		target := filepath.Join(dest, e.rel)
+
+		if a.dryRun {
+			if err := a.planEntry(e, target); err != nil {
+				return false, fmt.Errorf("%s: %w", target, err)
+			}
+			continue
+		}
+
+		// create all directories between dest and target, using
+		// the matching source directories for the mode bits.
+		var dirs, sources []string
+		for dir, src := filepath.Dir(target), filepath.Dir(e.path); dir != dest; dir, src = filepath.Dir(dir), filepath.Dir(src) {
+			dirs = append(dirs, dir)
+			sources = append(sources, src)
+		}
+		for idx := len(dirs) - 1; idx >= 0; idx-- {
+			info, err := os.Stat(sources[idx])
+			if err != nil {
+				return changed, err
+			}
+
+			updated, err := ensureDirectory(dirs[idx], a.directoryMode(info), dirUID, dirGID)
+			if err != nil {
+				return changed, fmt.Errorf("%s: %w", dirs[idx], err)
+			}
+			changed = changed || updated
+		}
+
+		fileMode := a.fileMode
+		if fileMode == 0 {
+			fileMode = e.info.Mode()
+		}
+
+		updated, err := copyFile(e.path, target, fileMode, uid, gid, a.atomicOptions(ctx))
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", target, err)
+		}
+
+		if updated {
+			a.Debugf("updated %s", target)
+		}
+		changed = changed || updated
+	}
+
+	return changed, nil
+}
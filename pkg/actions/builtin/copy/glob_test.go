@@ -0,0 +1,109 @@
+package copy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+func TestSplitGlob(t *testing.T) {
+	cases := []struct {
+		pattern, base, rest string
+	}{
+		{"/srv/conf/*.conf", "/srv/conf", "*.conf"},
+		{"/srv/conf/**/*.conf", "/srv/conf", "**/*.conf"},
+		{"/srv/*/nginx/*.conf", "/srv", "*/nginx/*.conf"},
+		{"/*.conf", "/", "*.conf"},
+	}
+
+	for _, c := range cases {
+		base, rest := splitGlob(c.pattern)
+		assert.Equal(t, c.base, base, c.pattern)
+		assert.Equal(t, c.rest, rest, c.pattern)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*.conf", "foo.conf", true},
+		{"*.conf", "sub/foo.conf", false},
+		{"**/*.conf", "foo.conf", true},
+		{"**/*.conf", "a/b/foo.conf", true},
+		{"**/*.conf", "a/b/foo.txt", false},
+		{"a/**/foo.conf", "a/foo.conf", true},
+		{"a/**/foo.conf", "a/b/c/foo.conf", true},
+		{"a/**/foo.conf", "b/foo.conf", false},
+		{"**", "a/b/c", true},
+	}
+
+	for _, c := range cases {
+		ok, err := matchGlob(c.pattern, c.name)
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, ok, "%s ~ %s", c.pattern, c.name)
+	}
+
+	_, err := matchGlob("[", "a")
+	assert.Error(t, err)
+}
+
+func TestCanContainMatch(t *testing.T) {
+	cases := []struct {
+		pattern, dir string
+		ok           bool
+	}{
+		{"*.conf", ".", true},
+		{"*.conf", "sub", false},
+		{"*/nginx/*.conf", "site", true},
+		{"*/nginx/*.conf", "site/nginx", true},
+		{"*/nginx/*.conf", "site/apache", false},
+		{"*/nginx/*.conf", "site/nginx/sub", false},
+		{"a/**/*.conf", "a/b/c/d", true},
+		{"a/**/*.conf", "b", false},
+	}
+
+	for _, c := range cases {
+		ok, err := canContainMatch(c.pattern, c.dir)
+		assert.NoError(t, err)
+		assert.Equal(t, c.ok, ok, "%s in %s", c.pattern, c.dir)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glob")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.conf", "b.txt", "sub/c.conf", "sub/deep/d.conf"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+	}
+	require.NoError(t, os.Symlink("a.conf", filepath.Join(dir, "link.conf")))
+
+	a := &action{Base: actions.Base{Logger: actions.NewLogger()}}
+
+	for pattern, expected := range map[string][]string{
+		"*.conf":       {"a.conf"},
+		"*/*.conf":     {"sub/c.conf"},
+		"**/*.conf":    {"a.conf", "sub/c.conf", "sub/deep/d.conf"},
+		"sub/**/*.txt": nil,
+	} {
+		matches, err := a.glob(filepath.Join(dir, pattern))
+		require.NoError(t, err)
+
+		var names []string
+		for _, m := range matches {
+			names = append(names, m.rel)
+		}
+		assert.Equal(t, expected, names, pattern)
+	}
+}
@@ -0,0 +1,472 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/change"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+// Supported values for the Symlinks= option.
+const (
+	symlinksFollow   = "follow"
+	symlinksPreserve = "preserve"
+	symlinksSkip     = "skip"
+)
+
+// entry is a file or directory below the source directory.
+type entry struct {
+	// path is the absolute path of the entry.
+	path string
+	// rel is the path of the entry relative to the source
+	// directory.
+	rel string
+	// info is the result of lstat(2) or stat(2) if the entry
+	// is a symbolic link that should be followed.
+	info os.FileInfo
+}
+
+// sourceEntries returns all entries below the source directory
+// with directories always preceding their content. Entries matching
+// Exclude= are omitted and symbolic links are handled as configured
+// by Symlinks=.
+func (a *action) sourceEntries() ([]entry, error) {
+	info, err := os.Stat(a.source)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []entry{{path: a.source, rel: ".", info: info}}
+	visited := map[string]bool{}
+
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		// protect against symlink loops when following links.
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return fmt.Errorf("%s: symbolic link loop detected", dir)
+		}
+		visited[real] = true
+		defer delete(visited, real)
+
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			e := entry{
+				path: filepath.Join(dir, info.Name()),
+				rel:  filepath.Join(rel, info.Name()),
+				info: info,
+			}
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				switch a.symlinks {
+				case symlinksSkip:
+					a.Debugf("skipping symbolic link %s", e.path)
+					continue
+				case symlinksFollow:
+					if e.info, err = os.Stat(e.path); err != nil {
+						return err
+					}
+				}
+			}
+
+			if a.excluded(e.rel, e.info.IsDir()) {
+				a.Debugf("excluding %s", e.path)
+				continue
+			}
+
+			entries = append(entries, e)
+
+			if e.info.IsDir() {
+				if err := walk(e.path, e.rel); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(a.source, "."); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// excluded returns true if the relative path rel matches one of the
+// Exclude= patterns. Patterns that contain a path separator are
+// matched against the relative path while all other patterns are
+// matched against the base name only. Patterns with a trailing
+// separator only match directories.
+func (a *action) excluded(rel string, isDir bool) bool {
+	for _, pattern := range a.exclude {
+		if strings.HasSuffix(pattern, string(filepath.Separator)) {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, string(filepath.Separator))
+		}
+
+		name := filepath.Base(rel)
+		if strings.Contains(pattern, string(filepath.Separator)) {
+			name = rel
+		}
+
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyDirectory recursively copies the source directory to dest.
+// Files are only written if their content differs and the mode and
+// ownership of all entries are updated if required. If Mirror= is
+// set, entries below dest that do not exist in the source directory
+// are removed. It returns true if at least one entry below dest has
+// been written, modified or removed.
+func (a *action) copyDirectory(ctx context.Context, dest string, uid, gid, dirUID, dirGID int) (bool, error) {
+	var changed bool
+
+	entries, err := a.sourceEntries()
+	if err != nil {
+		return false, err
+	}
+
+	expected := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		target := filepath.Join(dest, e.rel)
+		expected[e.rel] = true
+
+		if a.dryRun {
+			if err := a.planEntry(e, target); err != nil {
+				return false, fmt.Errorf("%s: %w", target, err)
+			}
+			continue
+		}
+
+		var updated bool
+		switch {
+		case e.info.IsDir():
+			updated, err = ensureDirectory(target, a.directoryMode(e.info), dirUID, dirGID)
+		case e.info.Mode()&os.ModeSymlink != 0:
+			updated, err = ensureSymlink(e.path, target, uid, gid)
+		case e.info.Mode().IsRegular():
+			fileMode := a.fileMode
+			if fileMode == 0 {
+				fileMode = e.info.Mode()
+			}
+			updated, err = copyFile(e.path, target, fileMode, uid, gid, a.atomicOptions(ctx))
+		default:
+			a.Warnf("skipping %s: unsupported file type %s", e.path, e.info.Mode()&os.ModeType)
+			continue
+		}
+
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", target, err)
+		}
+
+		if updated {
+			a.Debugf("updated %s", target)
+		}
+		changed = changed || updated
+	}
+
+	if a.mirror {
+		removed, err := a.removeExtraneous(dest, expected)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || removed
+	}
+
+	return changed, nil
+}
+
+// planEntry logs what would happen to target when copying e. It
+// is used for DryRun=yes.
+func (a *action) planEntry(e entry, target string) error {
+	stat, err := os.Lstat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+
+	switch {
+	case e.info.IsDir():
+		if !exists {
+			a.Infof("would create directory %s", target)
+		}
+	case e.info.Mode()&os.ModeSymlink != 0:
+		linkTarget, err := os.Readlink(e.path)
+		if err != nil {
+			return err
+		}
+
+		current := ""
+		if exists && stat.Mode()&os.ModeSymlink != 0 {
+			if current, err = os.Readlink(target); err != nil {
+				return err
+			}
+		}
+
+		if current != linkTarget {
+			a.Infof("would link %s to %s", target, linkTarget)
+		}
+	case e.info.Mode().IsRegular():
+		if exists && stat.Mode()&os.ModeSymlink != 0 {
+			a.Infof("would replace symbolic link %s with %s", target, e.path)
+			return nil
+		}
+		if exists && !stat.Mode().IsRegular() {
+			return fmt.Errorf("exists but is not a regular file")
+		}
+
+		updateRequired, err := change.FileUpdateNeeded(e.path, target)
+		if err != nil {
+			return err
+		}
+
+		if updateRequired {
+			a.Infof("would copy %s to %s", e.path, target)
+		}
+	}
+
+	return nil
+}
+
+// removeExtraneous removes all entries below dest that are not
+// part of expected and are not excluded. Symbolic links below
+// dest are never followed. It returns true if at least one
+// entry has been removed.
+func (a *action) removeExtraneous(dest string, expected map[string]bool) (bool, error) {
+	var changed bool
+
+	if err := checkMirrorDestination(dest); err != nil {
+		return false, err
+	}
+
+	if _, err := os.Lstat(dest); os.IsNotExist(err) && a.dryRun {
+		return false, nil
+	}
+
+	err := filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dest, path)
+		if err != nil {
+			return err
+		}
+
+		if expected[rel] {
+			return nil
+		}
+
+		if a.excluded(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// this should never happen but make sure we never delete
+		// anything outside of dest.
+		if !strings.HasPrefix(path, dest+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to remove %s: outside of %s", path, dest)
+		}
+
+		if a.dryRun {
+			a.Infof("would remove %s", path)
+		} else {
+			a.Debugf("removing %s", path)
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return changed, err
+}
+
+// checkMirrorDestination returns an error if dest is not suitable
+// for Mirror=yes.
+func checkMirrorDestination(dest string) error {
+	if !filepath.IsAbs(dest) {
+		return fmt.Errorf("refusing to mirror to relative path %q", dest)
+	}
+
+	real, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			real = filepath.Clean(dest)
+		} else {
+			return err
+		}
+	}
+
+	if filepath.Clean(dest) == "/" || real == "/" {
+		return fmt.Errorf("refusing to mirror to /")
+	}
+
+	return nil
+}
+
+// copyFile copies src to dst if their content differs and ensures
+// that dst has the given mode and owner. opts is passed to
+// utils.CopyAtomicOwner. A symbolic link at dst is never followed but
+// replaced. It returns true if dst has been written or modified.
+func copyFile(src, dst string, mode os.FileMode, uid, gid int, opts *utils.AtomicOptions) (bool, error) {
+	// a symbolic link at dst is replaced instead of updating the
+	// file it points to.
+	stat, err := os.Lstat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	isLink := err == nil && stat.Mode()&os.ModeSymlink != 0
+
+	// check if we actually need to update dst.
+	updateRequired := isLink
+	if !isLink {
+		updateRequired, err = change.FileUpdateNeeded(src, dst)
+		if err != nil {
+			return false, fmt.Errorf("failed to check for required file update: %w", err)
+		}
+	}
+	if !updateRequired {
+		// file already exists and has the expected content, make sure
+		// we have the correct owner and file mode and we are done.
+		ownerChanged, err := change.EnsureFileOwner(dst, uid, gid)
+		if err != nil {
+			return false, err
+		}
+
+		modeChanged, err := change.EnsureFileMode(dst, mode)
+		return ownerChanged || modeChanged, err
+	}
+
+	// finally replace/create dst from src and apply the correct
+	// owner and file mode. If dst exists it will be overwritten.
+	if err := utils.CopyAtomicOwner(src, dst, mode, uid, gid, opts); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// directoryMode returns the mode for a directory copied from the
+// source directory described by info. Unless DirectoryMode= is set
+// the mode bits of the source directory are used.
+func (a *action) directoryMode(info os.FileInfo) os.FileMode {
+	if a.dirMode != 0 {
+		return a.dirMode
+	}
+
+	return info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// ensureDirectory makes sure path is a directory with the given
+// mode and owner. It returns true if path has been created or
+// modified.
+func ensureDirectory(path string, mode os.FileMode, uid, gid int) (bool, error) {
+	var changed bool
+
+	stat, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		changed = true
+	case err != nil:
+		return false, err
+	case stat.Mode()&os.ModeSymlink != 0:
+		// a symbolic link can safely be replaced without losing
+		// any data. This happens when switching to Symlinks=follow.
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+		changed = true
+	case !stat.IsDir():
+		return false, fmt.Errorf("exists but is not a directory")
+	}
+
+	if changed {
+		if err := os.Mkdir(path, mode); err != nil {
+			return false, err
+		}
+	}
+
+	ownerChanged, err := change.EnsureFileOwner(path, uid, gid)
+	if err != nil {
+		return changed, err
+	}
+
+	// os.Mkdir is subject to the umask so we need to check the
+	// mode bits even if the directory has just been created.
+	modeChanged, err := change.EnsureFileMode(path, os.ModeDir|mode)
+	if err != nil {
+		return changed, err
+	}
+
+	return changed || ownerChanged || modeChanged, nil
+}
+
+// ensureSymlink makes sure that path is a symbolic link pointing
+// to the same target as the symbolic link src.
+func ensureSymlink(src, path string, uid, gid int) (bool, error) {
+	linkTarget, err := os.Readlink(src)
+	if err != nil {
+		return false, err
+	}
+
+	stat, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return false, err
+	case stat.IsDir():
+		return false, fmt.Errorf("exists but is a directory")
+	case stat.Mode()&os.ModeSymlink != 0:
+		current, err := os.Readlink(path)
+		if err != nil {
+			return false, err
+		}
+
+		if current == linkTarget {
+			return change.EnsureFileOwner(path, uid, gid)
+		}
+		fallthrough
+	default:
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.Symlink(linkTarget, path); err != nil {
+		return false, err
+	}
+
+	if _, err := change.EnsureFileOwner(path, uid, gid); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
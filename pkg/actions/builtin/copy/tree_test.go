@@ -0,0 +1,203 @@
+package copy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+// newTreeAction returns an action that copies a source directory
+// containing the given files to a destination directory.
+func newTreeAction(t *testing.T, files map[string]os.FileMode) *action {
+	dir, err := ioutil.TempDir("", "copy")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	source := filepath.Join(dir, "src")
+	for name, mode := range files {
+		path := filepath.Join(source, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), mode))
+		require.NoError(t, os.Chmod(path, mode))
+	}
+
+	return &action{
+		Base:        actions.Base{Logger: actions.NewLogger()},
+		source:      source,
+		sourceIsDir: true,
+		destDir:     dir,
+		destName:    "dest",
+		dirMode:     0755,
+		symlinks:    symlinksPreserve,
+		preserve:    true,
+	}
+}
+
+func fileMode(t *testing.T, path string) os.FileMode {
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	return stat.Mode().Perm()
+}
+
+func TestCopyDirectory(t *testing.T) {
+	a := newTreeAction(t, map[string]os.FileMode{
+		"config":     0640,
+		"bin/script": 0755,
+	})
+	dest := filepath.Join(a.destDir, a.destName)
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	// without FileMode= the mode of each source file is used.
+	assert.Equal(t, os.FileMode(0640), fileMode(t, filepath.Join(dest, "config")))
+	assert.Equal(t, os.FileMode(0755), fileMode(t, filepath.Join(dest, "bin/script")))
+	assert.Equal(t, os.FileMode(0755), fileMode(t, filepath.Join(dest, "bin")))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// content and mode drift is corrected.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dest, "config"), []byte("modified"), 0640))
+	require.NoError(t, os.Chmod(filepath.Join(dest, "bin/script"), 0700))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "config", string(data))
+	assert.Equal(t, os.FileMode(0755), fileMode(t, filepath.Join(dest, "bin/script")))
+
+	// FileMode= applies to all files in the tree.
+	a.fileMode = 0600
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, os.FileMode(0600), fileMode(t, filepath.Join(dest, "config")))
+	assert.Equal(t, os.FileMode(0600), fileMode(t, filepath.Join(dest, "bin/script")))
+}
+
+func TestCopyDirectoryMode(t *testing.T) {
+	a := newTreeAction(t, map[string]os.FileMode{
+		"private/key": 0600,
+	})
+	a.dirMode = 0
+	dest := filepath.Join(a.destDir, a.destName)
+
+	require.NoError(t, os.Chmod(a.source, 0700))
+	require.NoError(t, os.Chmod(filepath.Join(a.source, "private"), 0750))
+	require.NoError(t, os.Mkdir(dest, 0700))
+
+	// without DirectoryMode= the mode of each source directory is used.
+	_, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), fileMode(t, dest))
+	assert.Equal(t, os.FileMode(0750), fileMode(t, filepath.Join(dest, "private")))
+
+	a.dirMode = 0755
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, os.FileMode(0755), fileMode(t, dest))
+	assert.Equal(t, os.FileMode(0755), fileMode(t, filepath.Join(dest, "private")))
+}
+
+func TestCopyDirectoryReplaceSymlink(t *testing.T) {
+	a := newTreeAction(t, map[string]os.FileMode{
+		"config": 0600,
+	})
+	dest := filepath.Join(a.destDir, a.destName)
+
+	// a link to a file with the same content must not be followed
+	// when fixing the mode bits.
+	outside := filepath.Join(a.destDir, "outside")
+	require.NoError(t, ioutil.WriteFile(outside, []byte("config"), 0644))
+	require.NoError(t, os.Chmod(outside, 0644))
+	require.NoError(t, os.Mkdir(dest, 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dest, "config")))
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	stat, err := os.Lstat(filepath.Join(dest, "config"))
+	require.NoError(t, err)
+	assert.True(t, stat.Mode().IsRegular())
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	assert.Equal(t, os.FileMode(0644), fileMode(t, outside))
+}
+
+func TestCopyDirectoryMirror(t *testing.T) {
+	a := newTreeAction(t, map[string]os.FileMode{
+		"keep":     0644,
+		"sub/keep": 0644,
+		"skip.tmp": 0644,
+	})
+	a.mirror = true
+	a.exclude = []string{"*.tmp", "cache/"}
+	dest := filepath.Join(a.destDir, a.destName)
+
+	for _, name := range []string{"extra", "sub/extra/file", "local.tmp", "cache/data"} {
+		path := filepath.Join(dest, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), 0644))
+	}
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	for _, name := range []string{"keep", "sub/keep", "local.tmp", "cache/data"} {
+		assert.FileExists(t, filepath.Join(dest, name))
+	}
+
+	for _, name := range []string{"extra", "sub/extra", "skip.tmp"} {
+		_, err := os.Lstat(filepath.Join(dest, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestCopyDirectoryDryRun(t *testing.T) {
+	a := newTreeAction(t, map[string]os.FileMode{
+		"new":     0644,
+		"sub/new": 0644,
+	})
+	a.mirror = true
+	a.dryRun = true
+	dest := filepath.Join(a.destDir, a.destName)
+
+	require.NoError(t, os.MkdirAll(dest, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dest, "extra"), []byte("extra"), 0644))
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	infos, err := ioutil.ReadDir(dest)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "extra", infos[0].Name())
+}
+
+func TestCheckMirrorDestination(t *testing.T) {
+	assert.Error(t, checkMirrorDestination("/"))
+	assert.Error(t, checkMirrorDestination("/srv/.."))
+	assert.Error(t, checkMirrorDestination("relative/path"))
+	assert.NoError(t, checkMirrorDestination("/srv/www"))
+}
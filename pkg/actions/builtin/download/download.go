@@ -0,0 +1,514 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/change"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "Download",
+		Description: "Download files via HTTP(S)",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"If Checksum= is set and Destination already has the expected checksum the download is skipped entirely. " +
+					"Otherwise a conditional request is sent using the ETag and Last-Modified headers of the last response " +
+					"(If-None-Match and If-Modified-Since). They are stored in the `user.system-deploy.etag` and " +
+					"`user.system-deploy.last-modified` extended attributes together with the SHA-256 checksum of the downloaded " +
+					"content (`user.system-deploy.sha256`). If Destination has been modified locally, no conditional request is sent. " +
+					"The modification time of Destination is set to the Last-Modified header of the response. " +
+					"A downloaded file that is identical to Destination does not mark the task as changed.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "URL",
+				Description: "The HTTP or HTTPS URL to download.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Destination",
+				Description: "The absolute path to store the file. If it ends with a path separator the last path segment of URL= is used as the file name.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Checksum",
+				Description: "The expected checksum of the file in the form `algorithm:hex`. Supported algorithms are `sha256` and `sha512`. If the checksum does not match, Destination is not modified.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "FileMode",
+				Description: "The mode bits for Destination.",
+				Type:        conf.IntType,
+				Default:     "0644",
+			},
+			{
+				Name:        "Owner",
+				Description: "The owner (name or ID) of Destination. If unset, the owner of an existing file is kept.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Group",
+				Description: "The group (name or ID) of Destination. If unset, the group of an existing file is kept.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Header",
+				Description: "An additional HTTP header in the form `Name: Value`. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "Username",
+				Description: "The username for HTTP basic authentication.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Password",
+				Description: "The password for HTTP basic authentication.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Timeout",
+				Description: "The timeout in seconds for the whole download. Defaults to no timeout.",
+				Type:        conf.IntType,
+			},
+		},
+	})
+}
+
+// Extended attributes used to store the validators for conditional
+// requests.
+const (
+	// etagAttr stores the ETag of a downloaded file.
+	etagAttr = "user.system-deploy.etag"
+	// lastModifiedAttr stores the Last-Modified header of a
+	// downloaded file.
+	lastModifiedAttr = "user.system-deploy.last-modified"
+	// checksumAttr stores the SHA-256 checksum of the downloaded
+	// content. It's used to detect local modifications.
+	checksumAttr = "user.system-deploy.sha256"
+)
+
+// errUnchanged is returned from the validation function if the
+// downloaded file is identical to the current destination.
+var errUnchanged = errors.New("content unchanged")
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	rawURL, err := sec.GetString("URL")
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid value for URL: unsupported scheme %q", u.Scheme)
+	}
+
+	dest, err := sec.GetString("Destination")
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(dest, string(filepath.Separator)) {
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return nil, fmt.Errorf("cannot determine file name from URL %q", rawURL)
+		}
+		dest = filepath.Join(dest, name)
+	}
+
+	if !filepath.IsAbs(dest) {
+		return nil, fmt.Errorf("Destination must be absolute: %q", dest)
+	}
+
+	a := &action{
+		url:         u.String(),
+		destination: filepath.Clean(dest),
+		header:      make(http.Header),
+	}
+
+	if checksum, err := sec.GetString("Checksum"); err == nil {
+		if a.newHash, a.checksum, err = parseChecksum(checksum); err != nil {
+			return nil, fmt.Errorf("invalid value for Checksum: %w", err)
+		}
+	} else if !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	mode, err := sec.GetInt("FileMode")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, fmt.Errorf("invalid value for FileMode: %w", err)
+		}
+		mode = 0644
+	}
+	if mode < 0 || mode > 0777 {
+		return nil, fmt.Errorf("invalid value for FileMode: %o", mode)
+	}
+	a.mode = os.FileMode(mode)
+
+	if a.owner, err = sec.GetString("Owner"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	if a.group, err = sec.GetString("Group"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	for _, h := range sec.GetStringSlice("Header") {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid value for Header: %q", h)
+		}
+		a.header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	if a.username, err = sec.GetString("Username"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	if a.password, err = sec.GetString("Password"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	timeout, err := sec.GetInt("Timeout")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for Timeout: %w", err)
+	}
+	a.timeout = time.Duration(timeout) * time.Second
+
+	return a, nil
+}
+
+// parseChecksum parses a checksum in the form algorithm:hex.
+func parseChecksum(value string) (func() hash.Hash, []byte, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("expected algorithm:hex")
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(parts[0]) {
+	case "sha256":
+		newHash = sha256.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported algorithm %q", parts[0])
+	}
+
+	sum, err := hex.DecodeString(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(sum) != newHash().Size() {
+		return nil, nil, fmt.Errorf("invalid %s checksum length", parts[0])
+	}
+
+	return newHash, sum, nil
+}
+
+type action struct {
+	actions.Base
+
+	url         string
+	destination string
+	newHash     func() hash.Hash
+	checksum    []byte
+	mode        os.FileMode
+	owner       string
+	group       string
+	header      http.Header
+	username    string
+	password    string
+	timeout     time.Duration
+
+	// client is the HTTP client to use. It defaults to
+	// http.DefaultClient.
+	client *http.Client
+}
+
+func (a *action) Name() string {
+	return "Download " + a.url
+}
+
+func (a *action) Execute(ctx context.Context) (bool, error) {
+	uid, gid, err := utils.LookupOwner(a.owner, a.group)
+	if err != nil {
+		return false, err
+	}
+
+	stat, err := os.Stat(a.destination)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	if exists && !stat.Mode().IsRegular() {
+		return false, fmt.Errorf("%s exists but is not a regular file", a.destination)
+	}
+
+	var currentSum []byte
+	if exists {
+		if currentSum, err = fileHash(a.destination, a.hashFunc()); err != nil {
+			return false, err
+		}
+	}
+
+	if exists && a.checksum != nil && string(currentSum) == string(a.checksum) {
+		a.Debugf("%s already has the expected checksum", a.destination)
+		return a.ensurePermissions(uid, gid)
+	}
+
+	downloaded, err := a.download(ctx, stat, currentSum, uid, gid)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := a.ensurePermissions(uid, gid)
+	if err != nil {
+		return downloaded, err
+	}
+
+	return downloaded || updated, nil
+}
+
+// download performs the HTTP request and replaces the destination
+// file if required. stat and currentSum describe the current
+// destination file and are nil if it does not exist. It returns
+// true if the destination file has been replaced.
+func (a *action) download(ctx context.Context, stat os.FileInfo, currentSum []byte, uid, gid int) (bool, error) {
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, a.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	for key, values := range a.header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+
+	if a.username != "" || a.password != "" {
+		req.SetBasicAuth(a.username, a.password)
+	}
+
+	// conditional requests are only used if we don't know the
+	// expected checksum. Otherwise we already know that the
+	// destination needs to be updated.
+	if stat != nil && a.checksum == nil {
+		a.setConditionalHeaders(req, currentSum)
+	}
+
+	client := a.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		a.Debugf("%s has not been modified", a.url)
+		return false, nil
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return false, fmt.Errorf("failed to download %s: %s", a.url, res.Status)
+	}
+
+	sum := a.hashFunc()()
+
+	opts := &utils.AtomicOptions{
+		Validate: func(string) error {
+			actual := sum.Sum(nil)
+
+			if a.checksum != nil && string(actual) != string(a.checksum) {
+				return fmt.Errorf("checksum mismatch: expected %x but got %x", a.checksum, actual)
+			}
+
+			if currentSum != nil && string(actual) == string(currentSum) {
+				return errUnchanged
+			}
+
+			return nil
+		},
+		ExplicitMode: true,
+	}
+
+	err = utils.CreateAtomicOwner(a.destination, a.mode, uid, gid, io.TeeReader(res.Body, sum), opts)
+
+	replaced := true
+	if errors.Is(err, errUnchanged) {
+		a.Debugf("%s did not change", a.destination)
+		replaced, err = false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// the checksum is only required for conditional requests which
+	// are not used if Checksum= is set.
+	var checksum string
+	if a.checksum == nil {
+		checksum = hex.EncodeToString(sum.Sum(nil))
+	}
+	a.storeCacheInfo(res, checksum)
+
+	return replaced, nil
+}
+
+// setConditionalHeaders adds If-None-Match and If-Modified-Since to
+// req using the validators stored by storeCacheInfo. They are only
+// used if the destination has not been modified since it has been
+// downloaded, that is, if currentSum matches the stored checksum.
+// currentSum must be a SHA-256 checksum.
+func (a *action) setConditionalHeaders(req *http.Request, currentSum []byte) {
+	stored, err := utils.GetXattr(a.destination, checksumAttr)
+	if err != nil || string(stored) != hex.EncodeToString(currentSum) {
+		a.Debugf("%s has been modified locally, not sending a conditional request", a.destination)
+		return
+	}
+
+	if etag, err := utils.GetXattr(a.destination, etagAttr); err == nil && len(etag) > 0 {
+		req.Header.Set("If-None-Match", string(etag))
+	}
+
+	if lastModified, err := utils.GetXattr(a.destination, lastModifiedAttr); err == nil && len(lastModified) > 0 {
+		req.Header.Set("If-Modified-Since", string(lastModified))
+	}
+}
+
+// storeCacheInfo stores the ETag and Last-Modified header of res
+// as well as the checksum of the downloaded content so they can be
+// used for conditional requests. Errors are only logged as they
+// just disable conditional requests.
+func (a *action) storeCacheInfo(res *http.Response, checksum string) {
+	for _, attr := range []struct {
+		name, value string
+	}{
+		{etagAttr, res.Header.Get("ETag")},
+		{lastModifiedAttr, res.Header.Get("Last-Modified")},
+		{checksumAttr, checksum},
+	} {
+		// validators of previous responses are preserved when the
+		// destination is replaced, so remove them if the response
+		// does not have them.
+		var err error
+		if attr.value != "" {
+			err = utils.SetXattr(a.destination, attr.name, []byte(attr.value))
+		} else {
+			err = utils.RemoveXattr(a.destination, attr.name)
+		}
+
+		if err != nil {
+			a.Debugf("failed to store %s: %s", attr.name, err)
+		}
+	}
+
+	if lastModified := res.Header.Get("Last-Modified"); lastModified != "" {
+		t, err := http.ParseTime(lastModified)
+		if err != nil {
+			a.Debugf("invalid Last-Modified header: %s", err)
+			return
+		}
+
+		if err := os.Chtimes(a.destination, time.Now(), t); err != nil {
+			a.Debugf("failed to update modification time: %s", err)
+		}
+	}
+}
+
+// hashFunc returns the hash function used to compare files. It
+// defaults to SHA-256 if Checksum= is not set.
+func (a *action) hashFunc() func() hash.Hash {
+	if a.newHash == nil {
+		return sha256.New
+	}
+	return a.newHash
+}
+
+// ensurePermissions ensures that the destination has the
+// correct mode and owner.
+func (a *action) ensurePermissions(uid, gid int) (bool, error) {
+	ownerChanged, err := change.EnsureFileOwner(a.destination, uid, gid)
+	if err != nil {
+		return false, err
+	}
+
+	modeChanged, err := change.EnsureFileMode(a.destination, a.mode)
+	if err != nil {
+		return ownerChanged, err
+	}
+
+	return ownerChanged || modeChanged, nil
+}
+
+// fileHash returns the checksum of path using newHash.
+func fileHash(path string, newHash func() hash.Hash) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+const example = `[Task]
+Description= Install the latest release of foo
+
+[Download]
+URL=https://example.com/releases/foo-1.0.0-linux-amd64
+Destination=/usr/local/bin/foo
+Checksum=sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+FileMode=0755
+Owner=root
+`
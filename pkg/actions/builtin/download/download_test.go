@@ -0,0 +1,163 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+func newTestAction(t *testing.T, url string) *action {
+	dir, err := ioutil.TempDir("", "download")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return &action{
+		Base:        actions.Base{Logger: actions.NewLogger()},
+		url:         url,
+		destination: filepath.Join(dir, "file"),
+		mode:        0644,
+		header:      make(http.Header),
+	}
+}
+
+func TestDownloadConditional(t *testing.T) {
+	var requests int
+	content := "hello world"
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "secret", pass)
+
+		http.ServeContent(w, r, "file", modTime, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	a := newTestAction(t, srv.URL)
+	a.header.Set("X-Foo", "bar")
+	a.username = "user"
+	a.password = "secret"
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := ioutil.ReadFile(a.destination)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	stat, err := os.Stat(a.destination)
+	require.NoError(t, err)
+	assert.True(t, stat.ModTime().Equal(modTime))
+
+	// the second request is answered with 304 Not Modified
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 2, requests)
+}
+
+func TestDownloadLocalModification(t *testing.T) {
+	content := "hello world"
+
+	for _, etag := range []string{"", `"v1"`} {
+		// the server answers any conditional request with 304 and
+		// does not send a Last-Modified header.
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-Modified-Since") != "" || r.Header.Get("If-None-Match") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			_, _ = w.Write([]byte(content))
+		}))
+
+		a := newTestAction(t, srv.URL)
+
+		changed, err := a.Execute(context.Background())
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		require.NoError(t, ioutil.WriteFile(a.destination, []byte("modified"), 0644))
+
+		changed, err = a.Execute(context.Background())
+		require.NoError(t, err)
+		assert.True(t, changed, etag)
+
+		data, err := ioutil.ReadFile(a.destination)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data), etag)
+
+		srv.Close()
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	var requests int
+	content := "hello world"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(content))
+
+	a := newTestAction(t, srv.URL)
+	a.newHash = sha256.New
+	a.checksum = sum[:]
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	// the destination already matches the checksum so no
+	// request should be sent.
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 1, requests)
+
+	// a checksum mismatch must not touch the destination.
+	content = "something else"
+	require.NoError(t, os.Remove(a.destination))
+
+	_, err = a.Execute(context.Background())
+	assert.Error(t, err)
+
+	_, err = os.Stat(a.destination)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseChecksum(t *testing.T) {
+	_, sum, err := parseChecksum("sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9")
+	require.NoError(t, err)
+	assert.Len(t, sum, sha256.Size)
+
+	_, _, err = parseChecksum("md5:5eb63bbbe01eeed093cb22bb8f5acdc3")
+	assert.Error(t, err)
+
+	_, _, err = parseChecksum("sha256:abcd")
+	assert.Error(t, err)
+}
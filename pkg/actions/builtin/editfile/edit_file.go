@@ -1,8 +1,12 @@
 package editfile
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/khulnasoft-lab/system-conf/conf"
@@ -18,7 +22,7 @@ func init() {
 		Name:        "EditFile",
 		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
 		Website:     "https://github.com/khulnasoft-lab/system-deploy",
-		Description: "Manipulate existing files using SED like syntax",
+		Description: "Manipulate existing files using SED like syntax or regular expressions",
 		Example:     example,
 		Options: []conf.OptionSpec{
 			{
@@ -26,6 +30,42 @@ func init() {
 				Type:        conf.StringSliceType,
 				Description: "Apply an SED instruction on the target file. May be specified multiple times. Refer to https://github.com/rwtodd/Go.Sed for more information about the regexp syntax.",
 			},
+			{
+				Name:        "Replace",
+				Type:        conf.StringType,
+				Description: "A regular expression (Go syntax, see https://golang.org/pkg/regexp/syntax/) matched against the whole content of the target file. Mutually exclusive with Sed=.",
+			},
+			{
+				Name: "With",
+				Type: conf.StringType,
+				Description: "" +
+					"The replacement for all matches of Replace=. Capture groups can be referenced using `$$1` or `$${name}`. " +
+					"The `$` must be doubled because environment variables are substituted in all options. Defaults to an empty string.",
+			},
+			{
+				Name:        "Multiline",
+				Type:        conf.BoolType,
+				Default:     "no",
+				Description: "If set to yes, `^` and `$` in Replace= match at the beginning and end of each line instead of the whole file.",
+			},
+			{
+				Name:        "DotAll",
+				Type:        conf.BoolType,
+				Default:     "no",
+				Description: "If set to yes, `.` in Replace= also matches newlines.",
+			},
+			{
+				Name:        "MaxReplacements",
+				Type:        conf.IntType,
+				Default:     "0",
+				Description: "The maximum number of matches of Replace= to replace, starting at the beginning of the file. 0 means all matches.",
+			},
+			{
+				Name:        "ExpectMatch",
+				Type:        conf.BoolType,
+				Default:     "no",
+				Description: "If set to yes, the task fails if Replace= does not match the target file.",
+			},
 			{
 				Name:        "File",
 				Type:        conf.StringType,
@@ -37,6 +77,17 @@ func init() {
 				Type:        conf.BoolType,
 				Description: "Check if the file exists and if not, don't do anything.",
 			},
+			{
+				Name:        "PreserveAttributes",
+				Type:        conf.BoolType,
+				Default:     "yes",
+				Description: "If set to yes, the owner, group and extended attributes (including POSIX ACLs) of File= are preserved when it is replaced.",
+			},
+			{
+				Name:        "ValidateCommand",
+				Type:        conf.StringType,
+				Description: "A command to validate the modified file before it replaces File=, for example `sshd -t -f %s`. %s is replaced with the path of a temporary file. If the command fails File= is not modified. The command is not executed if the content of File= is unchanged.",
+			},
 		},
 		Setup: setup,
 	})
@@ -49,8 +100,14 @@ type editAction struct {
 	ignore     bool
 	skip       bool
 	engine     *sed.Engine
+	replace    *regexp.Regexp
+	with       string
+	max        int
+	expect     bool
 	hashBefore string
 	mode       os.FileMode
+	validator  *utils.Validator
+	discard    bool
 }
 
 func setup(task deploy.Task, section conf.Section) (actions.Action, error) {
@@ -61,16 +118,68 @@ func setup(task deploy.Task, section conf.Section) (actions.Action, error) {
 		return nil, err
 	}
 
-	actions := strings.NewReader(strings.Join(seds, " "))
-	engine, err := sed.New(actions)
-	if err != nil {
+	replace, err := section.Options.GetString("Replace")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	var (
+		engine *sed.Engine
+		re     *regexp.Regexp
+	)
+
+	if replace != "" {
+		if len(seds) > 0 {
+			return nil, fmt.Errorf("Sed= and Replace= are mutually exclusive")
+		}
+
+		re, err = compileReplace(section.Options, replace)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		actions := strings.NewReader(strings.Join(seds, " "))
+		engine, err = sed.New(actions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	with, err := section.Options.GetString("With")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	max, err := section.Options.GetInt("MaxReplacements")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for MaxReplacements: %w", err)
+	}
+	if max < 0 {
+		return nil, fmt.Errorf("invalid value for MaxReplacements: %d", max)
+	}
+
+	var validator *utils.Validator
+	cmd, err := section.Options.GetString("ValidateCommand")
+	if err != nil && !conf.IsNotSet(err) {
 		return nil, err
 	}
+	if cmd != "" {
+		validator, err = utils.NewValidator(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for ValidateCommand: %w", err)
+		}
+	}
 
 	return &editAction{
-		source: source,
-		ignore: ignore,
-		engine: engine,
+		source:    source,
+		ignore:    ignore,
+		engine:    engine,
+		replace:   re,
+		with:      with,
+		max:       int(max),
+		expect:    section.Options.GetBoolDefault("ExpectMatch", false),
+		validator: validator,
+		discard:   !section.Options.GetBoolDefault("PreserveAttributes", true),
 	}, nil
 }
 
@@ -101,21 +210,48 @@ func (action *editAction) Prepare(graph actions.ExecGraph) error {
 	return nil
 }
 
-func (action *editAction) Execute(_ context.Context) (bool, error) {
+func (action *editAction) Execute(ctx context.Context) (bool, error) {
 	// return now if the source file does not exist and
 	// IgnoreMissing= was set
 	if action.skip {
 		return false, nil
 	}
 
-	file, err := os.Open(action.source)
+	content, err := ioutil.ReadFile(action.source)
 	if err != nil {
 		return false, err
 	}
-	defer file.Close()
 
-	pipe := action.engine.Wrap(file)
-	if err := utils.CreateAtomic(action.source, action.mode, pipe); err != nil {
+	var result []byte
+	if action.replace != nil {
+		var count int
+		result, count = replaceAll(action.replace, content, action.with, action.max)
+		action.Debugf("replaced %d matches of %s", count, action.replace)
+
+		if count == 0 && action.expect {
+			return false, fmt.Errorf("%s: no match for %s", action.source, action.replace)
+		}
+	} else {
+		result, err = ioutil.ReadAll(action.engine.Wrap(bytes.NewReader(content)))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// don't touch the file, and don't run ValidateCommand=, if
+	// nothing has been changed.
+	if bytes.Equal(result, content) {
+		action.Debugf("%s is already up-to-date", action.source)
+		return false, nil
+	}
+
+	opts := &utils.AtomicOptions{
+		Validate:      action.validator.Func(ctx),
+		DiscardOwner:  action.discard,
+		DiscardXattrs: action.discard,
+	}
+
+	if err := utils.CreateAtomicOwner(action.source, action.mode, -1, -1, bytes.NewReader(result), opts); err != nil {
 		return false, err
 	}
 
@@ -127,6 +263,58 @@ func (action *editAction) Execute(_ context.Context) (bool, error) {
 	return checksum != action.hashBefore, nil
 }
 
+// compileReplace compiles expr honoring Multiline= and DotAll=.
+func compileReplace(opts conf.Options, expr string) (*regexp.Regexp, error) {
+	var flags string
+
+	if opts.GetBoolDefault("Multiline", false) {
+		flags += "m"
+	}
+
+	if opts.GetBoolDefault("DotAll", false) {
+		flags += "s"
+	}
+
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for Replace: %w", err)
+	}
+
+	return re, nil
+}
+
+// replaceAll is like re.ReplaceAll but replaces at most max
+// matches if max is greater than zero. It returns the result and the
+// number of replaced matches.
+func replaceAll(re *regexp.Regexp, content []byte, with string, max int) ([]byte, int) {
+	n := -1
+	if max > 0 {
+		n = max
+	}
+
+	matches := re.FindAllSubmatchIndex(content, n)
+	if len(matches) == 0 {
+		return content, 0
+	}
+
+	var (
+		result []byte
+		last   int
+	)
+
+	for _, match := range matches {
+		result = append(result, content[last:match[0]]...)
+		result = re.Expand(result, []byte(with), content, match)
+		last = match[1]
+	}
+
+	return append(result, content[last:]...), len(matches)
+}
+
 const example = `[Task]
 Description= Permit root login via SSH
 
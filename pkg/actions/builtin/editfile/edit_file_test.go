@@ -0,0 +1,104 @@
+package editfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+)
+
+func TestReplaceAll(t *testing.T) {
+	re := regexp.MustCompile(`(?m)^#?(Port) \d+$`)
+	content := []byte("#Port 22\nPort 23\nHost x\n")
+
+	result, count := replaceAll(re, content, "$1 2222", 0)
+	assert.Equal(t, "Port 2222\nPort 2222\nHost x\n", string(result))
+	assert.Equal(t, 2, count)
+
+	result, count = replaceAll(re, content, "${1} 2222", 1)
+	assert.Equal(t, "Port 2222\nPort 23\nHost x\n", string(result))
+	assert.Equal(t, 1, count)
+
+	result, count = replaceAll(regexp.MustCompile("nomatch"), content, "", 0)
+	assert.Equal(t, string(content), string(result))
+	assert.Equal(t, 0, count)
+}
+
+func TestEditFileTask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editfile")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "sshd_config")
+	require.NoError(t, ioutil.WriteFile(path, []byte("#Port 22\nHost x\n"), 0644))
+
+	// capture groups must be escaped from environment substitution.
+	tsk, err := deploy.Decode("test.task", strings.NewReader(`[EditFile]
+File=`+path+`
+Replace=^#?(?P<key>Port) \d+$
+Multiline=yes
+With=$${key} $$1
+`))
+	require.NoError(t, err)
+	require.NoError(t, deploy.ApplyEnvironment(tsk))
+
+	act, err := actions.Setup("EditFile", actions.NewLogger(), *tsk, tsk.Sections[0])
+	require.NoError(t, err)
+
+	ea := act.(*editAction)
+	require.NoError(t, ea.Prepare(nil))
+
+	changed, err := ea.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Port Port\nHost x\n", string(data))
+}
+
+func TestEditFileUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editfile")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "sshd_config")
+	require.NoError(t, ioutil.WriteFile(path, []byte("Port 22\n"), 0644))
+
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// neither the file is written nor ValidateCommand= is executed
+	// if nothing matches.
+	for _, opt := range []string{"Replace=^Port 23$\nWith=Port 22", "Sed=s/^Port 23$/Port 22/"} {
+		tsk, err := deploy.Decode("test.task", strings.NewReader(`[EditFile]
+File=`+path+`
+`+opt+`
+ValidateCommand=false %s
+`))
+		require.NoError(t, err)
+
+		act, err := actions.Setup("EditFile", actions.NewLogger(), *tsk, tsk.Sections[0])
+		require.NoError(t, err)
+
+		ea := act.(*editAction)
+		require.NoError(t, ea.Prepare(nil))
+
+		changed, err := ea.Execute(context.Background())
+		require.NoError(t, err, opt)
+		assert.False(t, changed)
+
+		after, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(before, after), opt)
+	}
+}
@@ -0,0 +1,279 @@
+package editstructured
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "EditStructured",
+		Description: "Set or delete keys in JSON, YAML and TOML files",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Paths and Values",
+				Description: "" +
+					"Paths consist of keys separated by dots, for example `log-opts.max-size`. Numeric keys address elements of arrays. " +
+					"A dot or equal sign that is part of a key must be escaped with a backslash. " +
+					"Values that are valid JSON (numbers, `true`, `false`, `null`, quoted strings, arrays and objects) are used with their type, " +
+					"everything else is used as a string. Use `\"true\"` to set the string `true`. Missing objects along the path are created.",
+			},
+			{
+				Title: "Formatting",
+				Description: "" +
+					"JSON files are written with an indentation of two spaces and keep the order of all keys. " +
+					"YAML files keep their comments and the order of keys but are re-indented with two spaces. " +
+					"TOML files are edited line by line so all other lines, including comments, are preserved. " +
+					"Keys within arrays of tables and inline tables cannot be modified in TOML files.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"All operations are applied to the parsed document. File= is only written if any of them changed a value, " +
+					"so reformatting is never reported as a change. The task is only marked as changed if File= has been modified.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "File",
+				Description: "The absolute path of the file to modify.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Format",
+				Description: "The format of File=. Either `json`, `yaml` or `toml`. Defaults to the file extension of File=.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Set",
+				Description: "Set a value in the form `path=value`. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "Delete",
+				Description: "Delete the value at path. Deletions are applied after all Set= operations. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "CreateFile",
+				Description: "If set to yes and File= does not exist it is created with mode 0644. Otherwise a missing file is an error.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "PreserveAttributes",
+				Description: "If set to yes, the owner, group and extended attributes (including POSIX ACLs) of File= are preserved when it is replaced.",
+				Type:        conf.BoolType,
+				Default:     "yes",
+			},
+		},
+	})
+}
+
+// operation is a single Set= or Delete= operation.
+type operation struct {
+	path []string
+	// value is nil for Delete=.
+	value *yaml.Node
+}
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	file, err := sec.GetString("File")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(file) {
+		return nil, fmt.Errorf("File must be absolute: %q", file)
+	}
+
+	a := &action{
+		file:       filepath.Clean(file),
+		createFile: sec.GetBoolDefault("CreateFile", false),
+		discard:    !sec.GetBoolDefault("PreserveAttributes", true),
+	}
+
+	a.format, err = sec.GetString("Format")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	if a.format == "" {
+		a.format = detectFormat(a.file)
+	}
+
+	switch a.format {
+	case formatJSON, formatYAML, formatTOML:
+	case "":
+		return nil, fmt.Errorf("cannot detect format of %s, please set Format=", a.file)
+	default:
+		return nil, fmt.Errorf("invalid value for Format: %q", a.format)
+	}
+
+	for _, set := range sec.GetStringSlice("Set") {
+		eq := indexUnescaped(set, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid value for Set: %q is not in the form path=value", set)
+		}
+
+		path, err := splitPath(set[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for Set: %w", err)
+		}
+
+		value, err := parseValue(set[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for Set: %w", err)
+		}
+
+		a.operations = append(a.operations, operation{path: path, value: value})
+	}
+
+	for _, del := range sec.GetStringSlice("Delete") {
+		path, err := splitPath(del)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for Delete: %w", err)
+		}
+
+		a.operations = append(a.operations, operation{path: path})
+	}
+
+	if len(a.operations) == 0 {
+		return nil, fmt.Errorf("at least one Set= or Delete= is required")
+	}
+
+	return a, nil
+}
+
+// detectFormat returns the format of path based on the file
+// extension or an empty string.
+func detectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return ""
+}
+
+// indexUnescaped returns the index of the first c in s that is not
+// preceded by a backslash or -1.
+func indexUnescaped(s string, c byte) int {
+	for idx := 0; idx < len(s); idx++ {
+		switch s[idx] {
+		case '\\':
+			idx++
+		case c:
+			return idx
+		}
+	}
+	return -1
+}
+
+type action struct {
+	actions.Base
+
+	file       string
+	format     string
+	operations []operation
+	createFile bool
+	discard    bool
+}
+
+func (a *action) Name() string {
+	return "EditStructured " + a.file
+}
+
+func (a *action) Execute(_ context.Context) (bool, error) {
+	mode := os.FileMode(0644)
+
+	content, err := ioutil.ReadFile(a.file)
+	exists := err == nil
+
+	switch {
+	case err == nil:
+		if mode, err = utils.FileMode(a.file); err != nil {
+			return false, err
+		}
+	case !os.IsNotExist(err):
+		return false, err
+	case !a.createFile:
+		return false, fmt.Errorf("%s does not exist, set CreateFile=yes to create it", a.file)
+	}
+
+	doc, err := parseDocument(a.format, content)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", a.file, err)
+	}
+
+	changed := false
+	for _, op := range a.operations {
+		var modified bool
+		if op.value != nil {
+			modified, err = doc.set(op.path, op.value)
+		} else {
+			modified, err = doc.delete(op.path)
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", a.file, err)
+		}
+
+		if modified {
+			a.Debugf("modified %s", joinPath(op.path))
+			changed = true
+		}
+	}
+
+	if !changed && exists {
+		return false, nil
+	}
+
+	data, err := doc.encode()
+	if err != nil {
+		return false, fmt.Errorf("failed to encode %s: %w", a.file, err)
+	}
+
+	opts := &utils.AtomicOptions{
+		DiscardOwner:  a.discard,
+		DiscardXattrs: a.discard,
+	}
+
+	if err := utils.CreateAtomicOwner(a.file, mode, -1, -1, bytes.NewReader(data), opts); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+const example = `[Task]
+Description= Configure the Docker daemon
+
+[EditStructured]
+File=/etc/docker/daemon.json
+CreateFile=yes
+Set=log-driver=json-file
+Set=log-opts.max-size=10m
+Set=live-restore=true
+Set=registry-mirrors=["https://mirror.example.com"]
+Delete=debug
+`
@@ -0,0 +1,177 @@
+package editstructured
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func apply(t *testing.T, format, input string, sets map[string]string, deletes ...string) (string, bool) {
+	doc, err := parseDocument(format, []byte(input))
+	require.NoError(t, err)
+
+	changed := false
+	for path, value := range sets {
+		p, err := splitPath(path)
+		require.NoError(t, err)
+
+		v, err := parseValue(value)
+		require.NoError(t, err)
+
+		modified, err := doc.set(p, v)
+		require.NoError(t, err)
+		changed = changed || modified
+	}
+
+	for _, path := range deletes {
+		p, err := splitPath(path)
+		require.NoError(t, err)
+
+		modified, err := doc.delete(p)
+		require.NoError(t, err)
+		changed = changed || modified
+	}
+
+	data, err := doc.encode()
+	require.NoError(t, err)
+
+	return string(data), changed
+}
+
+func TestJSON(t *testing.T) {
+	input := `{"b": 1, "a": {"x": "y"}, "debug": true}`
+
+	output, changed := apply(t, formatJSON, input, map[string]string{
+		"a.x":         "z",
+		"a.list":      `[1, "two"]`,
+		"b":           "1",
+		"log\\.level": "info",
+	}, "debug")
+
+	assert.True(t, changed)
+	assert.Equal(t, `{
+  "b": 1,
+  "a": {
+    "x": "z",
+    "list": [
+      1,
+      "two"
+    ]
+  },
+  "log.level": "info"
+}
+`, output)
+
+	_, changed = apply(t, formatJSON, input, map[string]string{"b": "1", "a.x": `"y"`}, "missing")
+	assert.False(t, changed)
+}
+
+func TestYAML(t *testing.T) {
+	input := "# comment\nserver:\n  port: 80 # the port\n  host: localhost\n"
+
+	output, changed := apply(t, formatYAML, input, map[string]string{"server.port": "8080"})
+	assert.True(t, changed)
+	assert.Equal(t, "# comment\nserver:\n  port: 8080 # the port\n  host: localhost\n", output)
+
+	_, changed = apply(t, formatYAML, input, map[string]string{"server.port": "80", "server.host": "localhost"})
+	assert.False(t, changed)
+}
+
+func TestTOML(t *testing.T) {
+	input := `# comment
+title = "x"
+
+[server]
+port = 80 # the port
+hosts = [
+  "a",
+  "b",
+]
+
+[[plugins]]
+port = 1
+`
+
+	output, changed := apply(t, formatTOML, input, map[string]string{
+		"server.port":  "8080",
+		"server.hosts": `["c"]`,
+		"server.tls":   "true",
+		"db.name":      "app",
+	}, "title")
+
+	assert.True(t, changed)
+	assert.Equal(t, `# comment
+
+[server]
+port = 8080 # the port
+hosts = ["c"]
+tls = true
+
+[[plugins]]
+port = 1
+
+[db]
+name = "app"
+`, output)
+
+	_, changed = apply(t, formatTOML, input, map[string]string{"server.port": "80", "title": `"x"`})
+	assert.False(t, changed)
+}
+
+func TestTOMLParentNotTable(t *testing.T) {
+	for _, input := range []string{"a = { b = 1 }\n", "a = 1\n", "[[a]]\nb = 1\n"} {
+		doc, err := parseDocument(formatTOML, []byte(input))
+		require.NoError(t, err)
+
+		value, err := parseValue("2")
+		require.NoError(t, err)
+
+		_, err = doc.set([]string{"a", "b"}, value)
+		assert.Error(t, err, input)
+
+		data, err := doc.encode()
+		require.NoError(t, err)
+		assert.Equal(t, input, string(data))
+	}
+}
+
+func TestTOMLDottedKeys(t *testing.T) {
+	output, changed := apply(t, formatTOML, "a.b = 1\n\n[x]\ny.z = 1\n", map[string]string{
+		"a.c":   "2",
+		"x.y.w": "2",
+	})
+
+	assert.True(t, changed)
+	assert.Equal(t, "a.b = 1\na.c = 2\n\n[x]\ny.z = 1\ny.w = 2\n", output)
+}
+
+func TestTOMLEqualValues(t *testing.T) {
+	cases := []struct {
+		current string
+		value   string
+		equal   bool
+	}{
+		{`'x'`, `"x"`, true},
+		{`"caf\u00e9"`, `"café"`, true},
+		{"\"\"\"\nmulti\nline\"\"\"", `"multi\nline"`, true},
+		{`[ 1,2 ,3, ]`, `[1, 2, 3]`, true},
+		{`1_000`, `1000`, true},
+		{`0x10`, `16`, true},
+		{`1.50`, `1.5`, true},
+		{`{ b = 2, a.c = true }`, `{"a": {"c": true}, "b": 2}`, true},
+		{`"1"`, `1`, false},
+		{`1.0`, `1`, false},
+		{`[1, 2]`, `[2, 1]`, false},
+	}
+
+	for _, c := range cases {
+		input := "key = " + c.current + " # comment\n"
+
+		output, changed := apply(t, formatTOML, input, map[string]string{"key": c.value})
+		assert.Equal(t, !c.equal, changed, c.current)
+		if c.equal {
+			assert.Equal(t, input, output)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package editstructured
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported values for Format=.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// document is a parsed file that can be modified.
+type document interface {
+	// set sets the value at path and returns true if the document
+	// has been changed.
+	set(path []string, value *yaml.Node) (bool, error)
+	// delete removes the value at path and returns true if the
+	// document has been changed.
+	delete(path []string) (bool, error)
+	// encode returns the content of the document.
+	encode() ([]byte, error)
+}
+
+// parseDocument parses data using format.
+func parseDocument(format string, data []byte) (document, error) {
+	switch format {
+	case formatJSON:
+		root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if len(bytes.TrimSpace(data)) > 0 {
+			var err error
+			if root, err = decodeJSON(data); err != nil {
+				return nil, err
+			}
+		}
+		return &treeDocument{format: format, root: root}, nil
+
+	case formatYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+			doc = yaml.Node{
+				Kind:    yaml.DocumentNode,
+				Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+			}
+		}
+		return &treeDocument{format: format, doc: &doc, root: doc.Content[0]}, nil
+
+	case formatTOML:
+		return parseTOML(data), nil
+	}
+
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// treeDocument is a JSON or YAML document.
+type treeDocument struct {
+	format string
+	// doc is the YAML document node. It is nil for JSON.
+	doc  *yaml.Node
+	root *yaml.Node
+}
+
+func (d *treeDocument) set(path []string, value *yaml.Node) (bool, error) {
+	return setNode(d.root, path, value)
+}
+
+func (d *treeDocument) delete(path []string) (bool, error) {
+	return deleteNode(d.root, path)
+}
+
+func (d *treeDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if d.format == formatJSON {
+		if err := encodeJSON(&buf, d.root, ""); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+		return buf.Bytes(), nil
+	}
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(d.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeJSON writes node as indented JSON to buf.
+func encodeJSON(buf *bytes.Buffer, node *yaml.Node, indent string) error {
+	node = resolveAlias(node)
+
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, close, step := "{", "}", 2
+		if node.Kind == yaml.SequenceNode {
+			open, close, step = "[", "]", 1
+		}
+
+		if len(node.Content) == 0 {
+			buf.WriteString(open + close)
+			return nil
+		}
+
+		buf.WriteString(open + "\n")
+		inner := indent + "  "
+
+		for idx := 0; idx < len(node.Content); idx += step {
+			buf.WriteString(inner)
+			if step == 2 {
+				buf.WriteString(quoteJSON(node.Content[idx].Value) + ": ")
+			}
+
+			if err := encodeJSON(buf, node.Content[idx+step-1], inner); err != nil {
+				return err
+			}
+
+			if idx+step < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+
+		buf.WriteString(indent + close)
+		return nil
+
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!str":
+			buf.WriteString(quoteJSON(node.Value))
+		case "!!int", "!!float":
+			if !json.Valid([]byte(node.Value)) {
+				return fmt.Errorf("%q is not a valid JSON number", node.Value)
+			}
+			buf.WriteString(node.Value)
+		case "!!bool":
+			buf.WriteString(strings.ToLower(node.Value))
+		case "!!null":
+			buf.WriteString("null")
+		default:
+			return fmt.Errorf("unsupported value %q", node.Value)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported node")
+}
+
+// quoteJSON returns s as a JSON string without escaping HTML
+// characters.
+func quoteJSON(s string) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// encoding a string cannot fail.
+	_ = enc.Encode(s)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
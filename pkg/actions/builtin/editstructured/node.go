@@ -0,0 +1,190 @@
+package editstructured
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setNode sets the value at path below root to value. Missing
+// mappings along the path are created. It returns true if the
+// document has been changed.
+func setNode(root *yaml.Node, path []string, value *yaml.Node) (bool, error) {
+	node := root
+
+	for idx, key := range path[:len(path)-1] {
+		child, err := lookup(node, key)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", joinPath(path[:idx+1]), err)
+		}
+
+		if child == nil {
+			if node.Kind != yaml.MappingNode {
+				return false, fmt.Errorf("%s: index out of range", joinPath(path[:idx+1]))
+			}
+
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, stringNode(key), child)
+		}
+
+		node = child
+	}
+
+	key := path[len(path)-1]
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if node.Content[idx].Value != key {
+				continue
+			}
+
+			current := node.Content[idx+1]
+			if equalNodes(current, value) {
+				return false, nil
+			}
+
+			value.LineComment = current.LineComment
+			node.Content[idx+1] = value
+			return true, nil
+		}
+
+		node.Content = append(node.Content, stringNode(key), value)
+		return true, nil
+
+	case yaml.SequenceNode:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx > len(node.Content) {
+			return false, fmt.Errorf("%s: invalid index %q", joinPath(path), key)
+		}
+
+		if idx == len(node.Content) {
+			node.Content = append(node.Content, value)
+			return true, nil
+		}
+
+		if equalNodes(node.Content[idx], value) {
+			return false, nil
+		}
+
+		node.Content[idx] = value
+		return true, nil
+	}
+
+	return false, fmt.Errorf("%s: parent is not a mapping or sequence", joinPath(path))
+}
+
+// deleteNode removes the value at path below root. It returns true
+// if the document has been changed.
+func deleteNode(root *yaml.Node, path []string) (bool, error) {
+	node := root
+
+	for idx, key := range path[:len(path)-1] {
+		child, err := lookup(node, key)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", joinPath(path[:idx+1]), err)
+		}
+
+		if child == nil {
+			return false, nil
+		}
+
+		node = child
+	}
+
+	key := path[len(path)-1]
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if node.Content[idx].Value == key {
+				node.Content = append(node.Content[:idx], node.Content[idx+2:]...)
+				return true, nil
+			}
+		}
+	case yaml.SequenceNode:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 {
+			return false, fmt.Errorf("%s: invalid index %q", joinPath(path), key)
+		}
+
+		if idx < len(node.Content) {
+			node.Content = append(node.Content[:idx], node.Content[idx+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// lookup returns the child of node identified by key or nil if it
+// does not exist.
+func lookup(node *yaml.Node, key string) (*yaml.Node, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if node.Content[idx].Value == key {
+				return resolveAlias(node.Content[idx+1]), nil
+			}
+		}
+		return nil, nil
+	case yaml.SequenceNode:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("invalid index %q", key)
+		}
+		if idx >= len(node.Content) {
+			return nil, nil
+		}
+		return resolveAlias(node.Content[idx]), nil
+	}
+
+	return nil, fmt.Errorf("not a mapping or sequence")
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// equalNodes returns true if a and b describe the same value. The
+// style and comments of the nodes are ignored.
+func equalNodes(a, b *yaml.Node) bool {
+	a, b = resolveAlias(a), resolveAlias(b)
+
+	if a.Kind != b.Kind || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	if a.Kind == yaml.ScalarNode {
+		return a.ShortTag() == b.ShortTag() && a.Value == b.Value
+	}
+
+	for idx := range a.Content {
+		if !equalNodes(a.Content[idx], b.Content[idx]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// joinPath is the reverse of splitPath.
+func joinPath(path []string) string {
+	var result string
+	for idx, p := range path {
+		if idx > 0 {
+			result += "."
+		}
+		for _, r := range p {
+			if r == '.' || r == '\\' {
+				result += "\\"
+			}
+			result += string(r)
+		}
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+package editstructured
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// splitPath splits path at each dot. A dot that is part of a key
+// must be escaped with a backslash.
+func splitPath(path string) ([]string, error) {
+	var (
+		parts   []string
+		current strings.Builder
+		escaped bool
+	)
+
+	for _, r := range path {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '.':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	parts = append(parts, current.String())
+
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid path %q: empty key", path)
+		}
+	}
+
+	return parts, nil
+}
+
+// parseValue parses the value of a Set= operation. Valid JSON (a
+// number, true, false, null, a quoted string, an array or an object)
+// is used as is, everything else is taken as a string.
+func parseValue(value string) (*yaml.Node, error) {
+	if !json.Valid([]byte(value)) {
+		return &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Tag:   "!!str",
+			Value: value,
+		}, nil
+	}
+
+	return decodeJSON([]byte(value))
+}
+
+// decodeJSON decodes a single JSON value into a node tree keeping
+// the order of object keys.
+func decodeJSON(data []byte) (*yaml.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	node, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+
+	return node, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if v == '[' {
+			node = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		}
+
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, stringNode(key.(string)))
+			}
+
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, value)
+		}
+
+		// consume the closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+	case string:
+		node := stringNode(v)
+		node.Style = yaml.DoubleQuotedStyle
+		return node, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(v)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+func stringNode(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
@@ -0,0 +1,488 @@
+package editstructured
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tomlDocument edits TOML files line by line so comments and
+// formatting of unrelated lines are preserved. It supports tables,
+// dotted keys and inline values. Keys within arrays of tables
+// ([[name]]) cannot be modified.
+type tomlDocument struct {
+	lines []string
+}
+
+// tomlEntry is a key/value pair that may span multiple lines.
+type tomlEntry struct {
+	// start and end are the line indexes of the entry (end is
+	// exclusive).
+	start, end int
+	// prefix holds everything up to the value.
+	prefix string
+	path   []string
+	// table is the path of the table the entry is defined in.
+	table []string
+	// value is the value without comments and comment the
+	// trailing comment including the whitespace before it.
+	value, comment string
+}
+
+// tomlTable is a table section started by a header line.
+type tomlTable struct {
+	// start is the line index of the header and end the index
+	// of the next header.
+	start, end int
+	path       []string
+	array      bool
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func parseTOML(data []byte) *tomlDocument {
+	doc := new(tomlDocument)
+	if len(data) > 0 {
+		doc.lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	return doc
+}
+
+// index parses the structure of the document. rootEnd is the index
+// of the first table header.
+func (d *tomlDocument) index() (entries []tomlEntry, tables []tomlTable, rootEnd int) {
+	var current *tomlTable
+	rootEnd = len(d.lines)
+
+	for row := 0; row < len(d.lines); {
+		line := d.lines[row]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			row++
+
+		case strings.HasPrefix(trimmed, "["):
+			array := strings.HasPrefix(trimmed, "[[")
+			inner := strings.TrimLeft(trimmed, "[")
+			if idx := strings.Index(inner, "]"); idx >= 0 {
+				inner = inner[:idx]
+			}
+
+			if current != nil {
+				current.end = row
+			} else {
+				rootEnd = row
+			}
+
+			tables = append(tables, tomlTable{
+				start: row,
+				end:   len(d.lines),
+				path:  splitTOMLKey(inner),
+				array: array,
+			})
+			current = &tables[len(tables)-1]
+			row++
+
+		default:
+			eq := indexOutsideQuotes(line, '=')
+			if eq < 0 {
+				row++
+				continue
+			}
+
+			valueStart := eq + 1
+			for valueStart < len(line) && (line[valueStart] == ' ' || line[valueStart] == '\t') {
+				valueStart++
+			}
+
+			end, value, comment := scanValue(d.lines, row, valueStart)
+
+			var path, table []string
+			if current == nil || !current.array {
+				if current != nil {
+					table = current.path
+				}
+				path = append(path, table...)
+				path = append(path, splitTOMLKey(line[:eq])...)
+			}
+
+			entries = append(entries, tomlEntry{
+				start:   row,
+				end:     end,
+				prefix:  line[:valueStart],
+				path:    path,
+				table:   table,
+				value:   value,
+				comment: comment,
+			})
+			row = end
+		}
+	}
+
+	return entries, tables, rootEnd
+}
+
+func (d *tomlDocument) set(path []string, value *yaml.Node) (bool, error) {
+	literal, err := tomlLiteral(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", joinPath(path), err)
+	}
+
+	entries, tables, rootEnd := d.index()
+
+	for _, e := range entries {
+		if !equalPath(e.path, path) {
+			continue
+		}
+
+		if e.value == literal || equalTOMLValue(e.value, value) {
+			return false, nil
+		}
+
+		d.replace(e.start, e.end, e.prefix+literal+e.comment)
+		return true, nil
+	}
+
+	for _, e := range entries {
+		// values (including inline tables) cannot be extended.
+		if e.path != nil && len(e.path) < len(path) && equalPath(e.path, path[:len(e.path)]) {
+			return false, fmt.Errorf("%s: parent %s is not a table", joinPath(path), joinPath(e.path))
+		}
+	}
+
+	for _, t := range tables {
+		if equalPath(t.path, path) {
+			return false, fmt.Errorf("%s is a table", joinPath(path))
+		}
+
+		if t.array && len(t.path) < len(path) && equalPath(t.path, path[:len(t.path)]) {
+			return false, fmt.Errorf("%s: parent %s is an array of tables", joinPath(path), joinPath(t.path))
+		}
+	}
+
+	parent := path[:len(path)-1]
+	line := formatTOMLKey(path[len(path)-1]) + " = " + literal
+
+	start, end := -1, -1
+	if len(parent) == 0 {
+		start, end = 0, rootEnd
+	} else {
+		for _, t := range tables {
+			if !t.array && equalPath(t.path, parent) {
+				start, end = t.start+1, t.end
+				break
+			}
+		}
+	}
+
+	if start == -1 {
+		// the table may be defined implicitly by dotted keys. It
+		// cannot be opened with a header then so add another
+		// dotted key after the last one.
+		last := -1
+		for idx, e := range entries {
+			if e.path != nil && len(e.table) <= len(parent) && len(e.path) > len(parent) &&
+				equalPath(e.path[:len(parent)], parent) {
+				last = idx
+			}
+		}
+
+		if last >= 0 {
+			e := entries[last]
+			keys := make([]string, 0, len(path)-len(e.table))
+			for _, key := range path[len(e.table):] {
+				keys = append(keys, formatTOMLKey(key))
+			}
+
+			indent := e.prefix[:len(e.prefix)-len(strings.TrimLeft(e.prefix, " \t"))]
+			d.replace(e.end, e.end, indent+strings.Join(keys, ".")+" = "+literal)
+			return true, nil
+		}
+
+		// the table does not exist yet.
+		if len(d.lines) > 0 && strings.TrimSpace(d.lines[len(d.lines)-1]) != "" {
+			d.lines = append(d.lines, "")
+		}
+
+		keys := make([]string, len(parent))
+		for idx, key := range parent {
+			keys[idx] = formatTOMLKey(key)
+		}
+
+		d.lines = append(d.lines, "["+strings.Join(keys, ".")+"]", line)
+		return true, nil
+	}
+
+	// insert after the last non-empty line of the table.
+	idx := end
+	for idx > start && strings.TrimSpace(d.lines[idx-1]) == "" {
+		idx--
+	}
+
+	d.replace(idx, idx, line)
+	return true, nil
+}
+
+func (d *tomlDocument) delete(path []string) (bool, error) {
+	entries, tables, _ := d.index()
+
+	remove := make([]bool, len(d.lines))
+	changed := false
+
+	mark := func(start, end int) {
+		for idx := start; idx < end; idx++ {
+			remove[idx] = true
+		}
+		changed = true
+	}
+
+	for _, e := range entries {
+		if e.path != nil && equalPath(e.path, path) {
+			mark(e.start, e.end)
+		}
+	}
+
+	for _, t := range tables {
+		if len(t.path) >= len(path) && equalPath(t.path[:len(path)], path) {
+			mark(t.start, t.end)
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	lines := d.lines[:0]
+	for idx, line := range d.lines {
+		if !remove[idx] {
+			lines = append(lines, line)
+		}
+	}
+	d.lines = lines
+
+	return true, nil
+}
+
+func (d *tomlDocument) encode() ([]byte, error) {
+	if len(d.lines) == 0 {
+		return nil, nil
+	}
+
+	return []byte(strings.Join(d.lines, "\n") + "\n"), nil
+}
+
+// replace replaces the lines from start to end (exclusive) with
+// line. If start equals end, line is inserted.
+func (d *tomlDocument) replace(start, end int, line string) {
+	lines := make([]string, 0, len(d.lines)+1)
+	lines = append(lines, d.lines[:start]...)
+	lines = append(lines, line)
+	lines = append(lines, d.lines[end:]...)
+	d.lines = lines
+}
+
+// scanValue scans the value that starts at column col of line row
+// and returns the index of the first line after the value, the value
+// without comments and the comment on the last line of the value.
+// Values span multiple lines if they contain multi-line strings or
+// unclosed arrays or inline tables.
+func scanValue(lines []string, row, col int) (int, string, string) {
+	var (
+		b     strings.Builder
+		depth int
+		// str holds the quote of the string we are in.
+		str     string
+		comment string
+	)
+
+	for ; row < len(lines); row, col = row+1, 0 {
+		line := lines[row]
+		comment = ""
+
+	scan:
+		for idx := col; idx < len(line); idx++ {
+			c := line[idx]
+
+			if str != "" {
+				switch {
+				case c == '\\' && str[0] == '"':
+					b.WriteByte(c)
+					if idx+1 < len(line) {
+						idx++
+						b.WriteByte(line[idx])
+					}
+				case strings.HasPrefix(line[idx:], str):
+					b.WriteString(str)
+					idx += len(str) - 1
+					str = ""
+				default:
+					b.WriteByte(c)
+				}
+				continue
+			}
+
+			switch {
+			case c == '#':
+				start := idx
+				for start > col && (line[start-1] == ' ' || line[start-1] == '\t') {
+					start--
+				}
+				comment = line[start:]
+				break scan
+			case strings.HasPrefix(line[idx:], `"""`), strings.HasPrefix(line[idx:], `'''`):
+				str = line[idx : idx+3]
+				b.WriteString(str)
+				idx += 2
+				continue
+			case c == '"' || c == '\'':
+				str = string(c)
+			case c == '[' || c == '{':
+				depth++
+			case c == ']' || c == '}':
+				depth--
+			}
+
+			b.WriteByte(c)
+		}
+
+		// single line strings cannot span multiple lines.
+		if len(str) == 1 {
+			str = ""
+		}
+
+		if depth <= 0 && str == "" {
+			return row + 1, strings.TrimSpace(b.String()), comment
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return len(lines), strings.TrimSpace(b.String()), comment
+}
+
+// indexOutsideQuotes returns the index of the first c in s that is
+// not part of a quoted string or -1.
+func indexOutsideQuotes(s string, c byte) int {
+	var quote byte
+
+	for idx := 0; idx < len(s); idx++ {
+		switch {
+		case quote != 0:
+			if s[idx] == '\\' && quote == '"' {
+				idx++
+			} else if s[idx] == quote {
+				quote = 0
+			}
+		case s[idx] == '"' || s[idx] == '\'':
+			quote = s[idx]
+		case s[idx] == c:
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// splitTOMLKey splits a (dotted) TOML key into its parts.
+func splitTOMLKey(key string) []string {
+	var parts []string
+
+	for {
+		key = strings.TrimSpace(key)
+		idx := indexOutsideQuotes(key, '.')
+
+		part := key
+		if idx >= 0 {
+			part = key[:idx]
+		}
+		part = strings.TrimSpace(part)
+
+		switch {
+		case strings.HasPrefix(part, `"`):
+			if unquoted, err := strconv.Unquote(part); err == nil {
+				part = unquoted
+			}
+		case strings.HasPrefix(part, "'"):
+			part = strings.Trim(part, "'")
+		}
+		parts = append(parts, part)
+
+		if idx < 0 {
+			return parts
+		}
+		key = key[idx+1:]
+	}
+}
+
+func formatTOMLKey(key string) string {
+	if bareKey.MatchString(key) {
+		return key
+	}
+	return quoteJSON(key)
+}
+
+// tomlLiteral formats value as a TOML value.
+func tomlLiteral(value *yaml.Node) (string, error) {
+	value = resolveAlias(value)
+
+	switch value.Kind {
+	case yaml.ScalarNode:
+		switch value.ShortTag() {
+		case "!!str":
+			return quoteJSON(value.Value), nil
+		case "!!int", "!!float":
+			return value.Value, nil
+		case "!!bool":
+			return strings.ToLower(value.Value), nil
+		case "!!null":
+			return "", fmt.Errorf("TOML does not support null values")
+		}
+		return "", fmt.Errorf("unsupported value %q", value.Value)
+
+	case yaml.SequenceNode:
+		items := make([]string, len(value.Content))
+		for idx, item := range value.Content {
+			literal, err := tomlLiteral(item)
+			if err != nil {
+				return "", err
+			}
+			items[idx] = literal
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+
+	case yaml.MappingNode:
+		if len(value.Content) == 0 {
+			return "{}", nil
+		}
+
+		items := make([]string, 0, len(value.Content)/2)
+		for idx := 0; idx+1 < len(value.Content); idx += 2 {
+			literal, err := tomlLiteral(value.Content[idx+1])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, formatTOMLKey(value.Content[idx].Value)+" = "+literal)
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+
+	return "", fmt.Errorf("unsupported value")
+}
+
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
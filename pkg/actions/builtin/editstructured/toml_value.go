@@ -0,0 +1,420 @@
+package editstructured
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tomlValueParser parses a single TOML value into a node tree so
+// values can be compared independent of their formatting.
+type tomlValueParser struct {
+	s   string
+	pos int
+}
+
+var (
+	tomlDate      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	tomlTimeAfter = regexp.MustCompile(`^ \d{2}:`)
+)
+
+// parseTOMLValue parses the TOML value s as returned by scanValue.
+func parseTOMLValue(s string) (*yaml.Node, error) {
+	p := &tomlValueParser{s: s}
+
+	node, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q after value", p.s[p.pos:])
+	}
+
+	return node, nil
+}
+
+func (p *tomlValueParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *tomlValueParser) value() (*yaml.Node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	switch p.s[p.pos] {
+	case '"', '\'':
+		str, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: str}, nil
+	case '[':
+		return p.array()
+	case '{':
+		return p.table()
+	}
+
+	return p.scalar()
+}
+
+// str parses any kind of string.
+func (p *tomlValueParser) str() (string, error) {
+	rest := p.s[p.pos:]
+
+	switch {
+	case strings.HasPrefix(rest, `"""`), strings.HasPrefix(rest, `'''`):
+		quote := rest[:3]
+		body := rest[3:]
+
+		// the closing quotes may be preceded by up to two quotes
+		// that are part of the string.
+		end := strings.Index(body, quote)
+		for end >= 0 && quote[0] == '"' && escapedAt(body, end) {
+			next := strings.Index(body[end+1:], quote)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += next + 1
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		for extra := 0; extra < 2 && end+3 < len(body) && body[end+3] == quote[0]; extra++ {
+			end++
+		}
+
+		p.pos += 3 + end + 3
+		content := body[:end]
+
+		// a newline right after the opening quotes is trimmed.
+		content = strings.TrimPrefix(strings.TrimPrefix(content, "\r"), "\n")
+		if quote[0] == '\'' {
+			return content, nil
+		}
+		return unescapeTOML(content, true)
+
+	case rest[0] == '\'':
+		end := strings.IndexByte(rest[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		p.pos += end + 2
+		return rest[1 : end+1], nil
+	}
+
+	for idx := 1; idx < len(rest); idx++ {
+		switch rest[idx] {
+		case '\\':
+			idx++
+		case '"':
+			p.pos += idx + 1
+			return unescapeTOML(rest[1:idx], false)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated string")
+}
+
+// escapedAt returns true if s[idx] is preceded by an odd number of
+// backslashes.
+func escapedAt(s string, idx int) bool {
+	count := 0
+	for idx > 0 && s[idx-1] == '\\' {
+		count++
+		idx--
+	}
+	return count%2 == 1
+}
+
+// unescapeTOML resolves the escape sequences of a basic string. In
+// multi-line strings a backslash at the end of a line trims all
+// following whitespace.
+func unescapeTOML(s string, multiline bool) (string, error) {
+	var b strings.Builder
+
+	for idx := 0; idx < len(s); idx++ {
+		c := s[idx]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+
+		idx++
+		if idx >= len(s) {
+			return "", fmt.Errorf("invalid escape sequence")
+		}
+
+		switch s[idx] {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'e':
+			b.WriteByte(0x1b)
+		case '"':
+			b.WriteByte('"')
+		case '\\':
+			b.WriteByte('\\')
+		case 'u', 'U':
+			size := 4
+			if s[idx] == 'U' {
+				size = 8
+			}
+			if idx+size >= len(s) {
+				return "", fmt.Errorf("invalid escape sequence")
+			}
+			code, err := strconv.ParseUint(s[idx+1:idx+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid escape sequence")
+			}
+			b.WriteRune(rune(code))
+			idx += size
+		default:
+			rest := strings.TrimLeft(s[idx:], " \t")
+			if !multiline || (!strings.HasPrefix(rest, "\n") && !strings.HasPrefix(rest, "\r\n")) {
+				return "", fmt.Errorf("invalid escape sequence \\%c", s[idx])
+			}
+			rest = strings.TrimLeft(rest, " \t\r\n")
+			idx = len(s) - len(rest) - 1
+		}
+	}
+
+	return b.String(), nil
+}
+
+func (p *tomlValueParser) array() (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	p.pos++
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if p.s[p.pos] == ']' {
+			p.pos++
+			return node, nil
+		}
+
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, item)
+
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.s) || p.s[p.pos] != ']' {
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlValueParser) table() (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	p.pos++
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("unterminated inline table")
+		}
+		if p.s[p.pos] == '}' {
+			p.pos++
+			return node, nil
+		}
+
+		eq := indexOutsideQuotes(p.s[p.pos:], '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("missing = in inline table")
+		}
+		path := splitTOMLKey(p.s[p.pos : p.pos+eq])
+		p.pos += eq + 1
+
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setTOMLKey(node, path, item); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.s) || p.s[p.pos] != '}' {
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// setTOMLKey sets the (dotted) key path of the mapping node to value.
+func setTOMLKey(node *yaml.Node, path []string, value *yaml.Node) error {
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value != path[0] {
+			continue
+		}
+
+		child := node.Content[idx+1]
+		if len(path) == 1 || child.Kind != yaml.MappingNode {
+			return fmt.Errorf("duplicate key %q", path[0])
+		}
+		return setTOMLKey(child, path[1:], value)
+	}
+
+	if len(path) > 1 {
+		child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if err := setTOMLKey(child, path[1:], value); err != nil {
+			return err
+		}
+		value = child
+	}
+
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}, value)
+	return nil
+}
+
+// scalar parses booleans, numbers and dates. Numbers are normalized
+// so they can be compared.
+func (p *tomlValueParser) scalar() (*yaml.Node, error) {
+	end := p.pos
+	for end < len(p.s) && strings.IndexByte(" \t\r\n,]}", p.s[end]) < 0 {
+		end++
+	}
+
+	// date and time may be separated by a space.
+	if tomlDate.MatchString(p.s[p.pos:end]) && tomlTimeAfter.MatchString(p.s[end:]) {
+		end++
+		for end < len(p.s) && strings.IndexByte(" \t\r\n,]}", p.s[end]) < 0 {
+			end++
+		}
+	}
+
+	token := p.s[p.pos:end]
+	p.pos = end
+
+	switch token {
+	case "true", "false":
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: token}, nil
+	case "":
+		return nil, fmt.Errorf("missing value")
+	}
+
+	if tag, value, ok := normalizeNumber(token); ok {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}, nil
+	}
+
+	if token[0] >= '0' && token[0] <= '9' {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp", Value: token}, nil
+	}
+
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// normalizeNumber returns the tag and the canonical form of the
+// TOML or JSON number s.
+func normalizeNumber(s string) (string, string, bool) {
+	clean := strings.ReplaceAll(s, "_", "")
+
+	if i, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return "!!int", strconv.FormatInt(i, 10), true
+	}
+
+	// this includes inf and nan.
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return "!!float", strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+
+	return "", "", false
+}
+
+// equalTOMLValue returns true if the TOML value raw is the same as
+// value. It returns false if raw cannot be parsed.
+func equalTOMLValue(raw string, value *yaml.Node) bool {
+	parsed, err := parseTOMLValue(raw)
+	if err != nil {
+		return false
+	}
+
+	return equalTOMLValues(parsed, normalizeTOMLNode(value))
+}
+
+// normalizeTOMLNode returns a copy of node with aliases resolved and
+// numbers normalized like parseTOMLValue does.
+func normalizeTOMLNode(node *yaml.Node) *yaml.Node {
+	node = resolveAlias(node)
+
+	result := &yaml.Node{Kind: node.Kind, Tag: node.ShortTag(), Value: node.Value}
+	if node.Kind == yaml.ScalarNode {
+		switch result.Tag {
+		case "!!int", "!!float":
+			if tag, value, ok := normalizeNumber(node.Value); ok {
+				result.Tag, result.Value = tag, value
+			}
+		case "!!bool":
+			result.Value = strings.ToLower(node.Value)
+		}
+	}
+
+	for _, child := range node.Content {
+		result.Content = append(result.Content, normalizeTOMLNode(child))
+	}
+
+	return result
+}
+
+// equalTOMLValues returns true if a and b, which must have been
+// normalized, are the same value. The order of keys in tables does
+// not matter.
+func equalTOMLValues(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	switch a.Kind {
+	case yaml.ScalarNode:
+		return a.Tag == b.Tag && a.Value == b.Value
+
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(a.Content); idx += 2 {
+			found := false
+			for other := 0; other+1 < len(b.Content); other += 2 {
+				if a.Content[idx].Value == b.Content[other].Value {
+					found = equalTOMLValues(a.Content[idx+1], b.Content[other+1])
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	for idx := range a.Content {
+		if !equalTOMLValues(a.Content[idx], b.Content[idx]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,13 +1,18 @@
 package exec
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/flynn/go-shlex"
 	"github.com/khulnasoft-lab/system-conf/conf"
 	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
 	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
@@ -21,12 +26,71 @@ func init() {
 		Setup:       setupAction,
 		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
 		Website:     "https://github.com/khulnasoft-lab/system-deploy",
-		Options: []conf.OptionSpec{
+		Help: []actions.HelpSection{
+			{
+				Title: "Commands and Scripts",
+				Description: "" +
+					"By default Command= is split into arguments using shell like quoting rules and executed directly without a shell. " +
+					"Set Shell= to execute each Command= using `<shell> -c <command>` instead so pipes, redirections and variable " +
+					"expansion work as expected. If Command= is specified multiple times the commands are executed in order and " +
+					"execution stops at the first failure. Script= is an alternative to Command= for longer scripts: all lines of Script= " +
+					"are written to a temporary file which is executed using Interpreter=. " +
+					"Note that environment variables are substituted by system-deploy in all options, including Command=, Script=, " +
+					"OnlyIf= and Unless=, before the shell sees them. Referencing a variable that is not set in the task environment is an " +
+					"error, so every `$` meant for the shell (like `$HOME`, `$1` or `$(date)`) must be written as `$$`.",
+			},
+			{
+				Title: "Sandboxing",
+				Description: "" +
+					"UMask=, Nice=, the Limit*= options, PrivateNetwork=, PrivateTmp=, NoNewPrivileges= and CapabilityBoundingSet= " +
+					"work like the options with the same name in systemd service units. They apply to Command=, Script=, OnlyIf= and " +
+					"Unless=. They are applied by a small helper process (system-deploy itself) right before the command is executed. " +
+					"PrivateNetwork= and PrivateTmp= require Linux namespaces and root privileges.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"By default the task is marked as changed whenever Command= is executed successfully. ChangedOnExit=, PristineOnExit=, " +
+					"ChangedIfOutputMatches= and PristineIfOutputMatches= are checked in that order and the first match decides. If none " +
+					"matches and only the Changed* options are set the task is marked as pristine, otherwise it is marked as changed. " +
+					"Output rules are matched against the combined stdout and stderr of the command, even if DisplayOutput= is set. " +
+					"Any exit code other than 0 that is not listed in SuccessExitStatus=, ChangedOnExit= or PristineOnExit= is a failure. " +
+					"For compatibility with earlier versions, no exit code is a failure if either ChangedOnExit= or PristineOnExit= is a " +
+					"single exit code and SuccessExitStatus= is not set. For example, with PristineOnExit=0 an exit code of 1 marks the " +
+					"task as changed. " +
+					"If Command= is specified multiple times the rules are applied to each command and the task is marked as changed if " +
+					"any of them changed something.",
+			},
+			{
+				Title: "Guards",
+				Description: "" +
+					"Creates=, Removes=, OnlyIf= and Unless= make Command= idempotent. All guards that are set must pass, otherwise " +
+					"Command= is skipped and the task is marked as pristine. Relative paths are resolved relative to WorkingDirectory= " +
+					"and, if Chroot= is set, all paths are resolved within the chroot. OnlyIf= and Unless= are executed with the same " +
+					"User=, Group=, Chroot=, WorkingDirectory= and Environment= as Command= but their output is never displayed.",
+			},
+		},
+		Options: append([]conf.OptionSpec{
 			{
 				Name:        "Command",
+				Type:        conf.StringSliceType,
+				Description: "The command to execute. May be specified multiple times. Either Command= or Script= is required.",
+			},
+			{
+				Name:        "Shell",
+				Type:        conf.StringType,
+				Description: "Execute each Command= (as well as OnlyIf= and Unless=) using the given shell, for example `/bin/sh` or `bash`.",
+			},
+			{
+				Name:        "Script",
+				Type:        conf.StringSliceType,
+				Description: "A line of a script to execute. May be specified multiple times. Mutually exclusive with Command=. Write `$$` for a `$` of the script.",
+			},
+			{
+				Name:        "Interpreter",
 				Type:        conf.StringType,
-				Description: "The command to execute.",
-				Required:    true,
+				Description: "The interpreter for Script=, optionally with arguments like `bash -e`. The path of the script is appended as the last argument.",
+				Default:     defaultInterpreter,
 			},
 			{
 				Name:        "WorkingDirectory",
@@ -65,17 +129,70 @@ func init() {
 				Type:        conf.StringSliceType,
 				Description: "Add environment variables for the command. The value should follow the format KEY=VALUE",
 			},
+			{
+				Name:        "LoginEnvironment",
+				Type:        conf.BoolType,
+				Default:     "no",
+				Description: "If set to yes, HOME, USER, LOGNAME, SHELL and PATH are set like for a login of User= (or the current user). PATH is taken from ENV_PATH or ENV_SUPATH in /etc/login.defs. Environment= takes precedence.",
+			},
+			{
+				Name:        "CleanEnvironment",
+				Type:        conf.BoolType,
+				Default:     "no",
+				Description: "If set to yes, the environment of system-deploy is not passed to the command. Only LoginEnvironment= and Environment= are set.",
+			},
+			{
+				Name:        "SupplementaryGroups",
+				Type:        conf.BoolType,
+				Default:     "yes",
+				Description: "If set to yes and User= is set, the supplementary groups of User= are read from /etc/group. If set to no, the supplementary groups of system-deploy are kept.",
+			},
 			{
 				Name:        "ChangedOnExit",
-				Type:        conf.IntType,
-				Description: "If set, the task will be marked as changed/updated if Command= returns with the specified exit code.",
+				Type:        conf.StringSliceType,
+				Description: "A list of exit codes or ranges (like `2 3` or `10-20`). If Command= exits with one of them the task is marked as changed/updated. These exit codes are not treated as failures.",
 			},
 			{
 				Name:        "PristineOnExit",
-				Type:        conf.IntType,
-				Description: "If set, the task will be marked as unchanged/pristine if Command= returns with the specified exit code.",
+				Type:        conf.StringSliceType,
+				Description: "A list of exit codes or ranges (like `2 3` or `10-20`). If Command= exits with one of them the task is marked as unchanged/pristine. These exit codes are not treated as failures.",
 			},
-		},
+			{
+				Name:        "SuccessExitStatus",
+				Type:        conf.StringSliceType,
+				Description: "A list of exit codes or ranges (like `2 3` or `10-20`) that are treated as success in addition to 0.",
+			},
+			{
+				Name:        "ChangedIfOutputMatches",
+				Type:        conf.StringType,
+				Description: "A regular expression matched against the output of Command=. If it matches the task is marked as changed/updated.",
+			},
+			{
+				Name:        "PristineIfOutputMatches",
+				Type:        conf.StringType,
+				Description: "A regular expression matched against the output of Command=. If it matches the task is marked as unchanged/pristine.",
+			},
+			{
+				Name:        "Creates",
+				Type:        conf.StringType,
+				Description: "A path that is created by Command=. If it exists, Command= is not executed.",
+			},
+			{
+				Name:        "Removes",
+				Type:        conf.StringType,
+				Description: "A path that is removed by Command=. If it does not exist, Command= is not executed.",
+			},
+			{
+				Name:        "OnlyIf",
+				Type:        conf.StringType,
+				Description: "A command that is executed before Command=. Command= is only executed if it exits with 0.",
+			},
+			{
+				Name:        "Unless",
+				Type:        conf.StringType,
+				Description: "A command that is executed before Command=. Command= is only executed if it exits with a non-zero exit code.",
+			},
+		}, sandboxOptions()...),
 	})
 }
 
@@ -139,12 +256,39 @@ func resolveUserGroup(userName, groupName string) (uid uint32, gid uint32, err e
 	return uid, gid, nil
 }
 
+// defaultInterpreter is the default value for Interpreter=.
+const defaultInterpreter = "/bin/sh"
+
 func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
-	cmd, err := sec.GetString("Command")
-	if err != nil {
+	commands := sec.GetStringSlice("Command")
+	script := sec.GetStringSlice("Script")
+
+	if len(commands) == 0 && len(script) == 0 {
+		return nil, fmt.Errorf("either Command= or Script= is required")
+	}
+
+	if len(commands) > 0 && len(script) > 0 {
+		return nil, fmt.Errorf("Command= and Script= are mutually exclusive")
+	}
+
+	shell, err := sec.GetString("Shell")
+	if err != nil && !conf.IsNotSet(err) {
 		return nil, err
 	}
 
+	interpreter, err := sec.GetString("Interpreter")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		interpreter = defaultInterpreter
+	}
+
+	interpreterArgs, err := shlex.Split(interpreter)
+	if err != nil || len(interpreterArgs) == 0 {
+		return nil, fmt.Errorf("invalid value for Interpreter: %q", interpreter)
+	}
+
 	workDir, err := sec.GetString("WorkingDirectory")
 	if err != nil {
 		if !conf.IsNotSet(err) {
@@ -197,65 +341,83 @@ func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
 		}
 	}
 
-	var exitCode *int64
-	ecChanged := false
-
-	exitCodeChanged, err := sec.GetInt("ChangedOnExit")
-	if err != nil && err != conf.ErrOptionNotSet {
+	rules, err := parseChangeRules(sec)
+	if err != nil {
 		return nil, err
 	}
-	if err != conf.ErrOptionNotSet {
-		exitCode = &exitCodeChanged
-		ecChanged = true
-	}
 
-	exitCodeNotChanged, err := sec.GetInt("PristineOnExit")
-	if err != nil && err != conf.ErrOptionNotSet {
-		return nil, err
+	a := &action{
+		taskDir:     workDir,
+		chroot:      chroot,
+		commands:    commands,
+		shell:       shell,
+		script:      script,
+		interpreter: interpreterArgs,
+		user:        userName,
+		group:       groupName,
+		pipeIn:      pipeIn,
+		pipeOut:     pipeOut,
+		environ:     environ,
+		rules:       rules,
 	}
 
-	if err != conf.ErrOptionNotSet {
-		if exitCode != nil {
-			return nil, fmt.Errorf("cannot use ChangedOnExit and PristineOnExit at the same time")
+	for _, guard := range []struct {
+		name  string
+		value *string
+	}{
+		{"Creates", &a.creates},
+		{"Removes", &a.removes},
+		{"OnlyIf", &a.onlyIf},
+		{"Unless", &a.unless},
+	} {
+		*guard.value, err = sec.GetString(guard.name)
+		if err != nil && !conf.IsNotSet(err) {
+			return nil, err
 		}
-
-		exitCode = &exitCodeNotChanged
-		ecChanged = false
 	}
 
-	a := &action{
-		taskDir:         workDir,
-		chroot:          chroot,
-		cmd:             cmd,
-		user:            userName,
-		group:           groupName,
-		pipeIn:          pipeIn,
-		pipeOut:         pipeOut,
-		environ:         environ,
-		exitCode:        exitCode,
-		exitCodeChanged: ecChanged,
+	if a.sandbox, err = parseSandbox(sec); err != nil {
+		return nil, err
 	}
 
+	a.loginEnv = sec.GetBoolDefault("LoginEnvironment", false)
+	a.cleanEnv = sec.GetBoolDefault("CleanEnvironment", false)
+	a.supplementaryGroups = sec.GetBoolDefault("SupplementaryGroups", true)
+
 	return a, nil
 }
 
 type action struct {
 	actions.Base
 
-	taskDir         string
-	chroot          string
-	user            string
-	group           string
-	cmd             string
-	environ         map[string]string
-	pipeOut         bool
-	pipeIn          bool
-	exitCode        *int64
-	exitCodeChanged bool
+	taskDir             string
+	chroot              string
+	user                string
+	group               string
+	commands            []string
+	shell               string
+	script              []string
+	interpreter         []string
+	environ             map[string]string
+	pipeOut             bool
+	pipeIn              bool
+	rules               *changeRules
+	creates             string
+	removes             string
+	onlyIf              string
+	unless              string
+	sandbox             *utils.Sandbox
+	loginEnv            bool
+	cleanEnv            bool
+	supplementaryGroups bool
 }
 
 func (a *action) Name() string {
-	return fmt.Sprintf("Running %q", strings.Split(a.cmd, "\n")[0])
+	if len(a.commands) == 0 {
+		return fmt.Sprintf("Running script using %q", strings.Join(a.interpreter, " "))
+	}
+
+	return fmt.Sprintf("Running %q", strings.Split(a.commands[0], "\n")[0])
 }
 
 // Prepare does nothing for exec.
@@ -264,57 +426,318 @@ func (a *action) Prepare(_ actions.ExecGraph) error {
 }
 
 func (a *action) Execute(ctx context.Context) (bool, error) {
+	run, err := a.checkGuards(ctx)
+	if err != nil || !run {
+		return false, err
+	}
+
 	var exitCode int64
+	opts, err := a.execOptions(&exitCode)
+	if err != nil {
+		return false, err
+	}
+
+	var steps [][]string
+	for _, cmd := range a.commands {
+		args, err := a.commandArgs(cmd)
+		if err != nil {
+			return false, err
+		}
+		steps = append(steps, args)
+	}
+
+	if len(a.script) > 0 {
+		path, err := a.writeScript()
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(path)
+
+		if a.chroot != "" {
+			path = strings.TrimPrefix(path, filepath.Clean(a.chroot))
+		}
+
+		steps = append(steps, append(append([]string(nil), a.interpreter...), path))
+	}
+
+	changed := false
+	for _, args := range steps {
+		var output bytes.Buffer
+		if a.rules.captureOutput() {
+			opts.Output = &output
+		}
+
+		if err := utils.ExecArgs(ctx, a.taskDir, args, opts); err != nil {
+			if _, ok := err.(*utils.ExitCodeError); !ok || !a.rules.successful(int(exitCode)) {
+				return changed, err
+			}
+		}
+
+		stepChanged, reason := a.rules.decide(int(exitCode), output.Bytes())
+
+		logf := a.Debugf
+		if a.rules.configured() {
+			logf = a.Infof
+		}
+
+		if stepChanged {
+			logf("%s: %s, marking as changed", args[0], reason)
+		} else {
+			logf("%s: %s, marking as pristine", args[0], reason)
+		}
+
+		changed = changed || stepChanged
+	}
+
+	return changed, nil
+}
+
+// commandArgs returns the arguments to execute cmd, either using
+// Shell= or by splitting cmd.
+func (a *action) commandArgs(cmd string) ([]string, error) {
+	if a.shell != "" {
+		return []string{a.shell, "-c", cmd}, nil
+	}
+
+	return shlex.Split(cmd)
+}
+
+// writeScript writes Script= to a temporary file that is readable
+// by User= and returns its path. If Chroot= is set the file is
+// created within the chroot. With PrivateTmp= the file is created in
+// /run because /tmp and /var/tmp are replaced before the script is
+// executed.
+func (a *action) writeScript() (string, error) {
+	dir := os.TempDir()
+	if a.sandbox != nil && a.sandbox.PrivateTmp {
+		dir = "/run"
+	}
+	if a.chroot != "" {
+		dir = filepath.Join(a.chroot, dir)
+	}
+
+	f, err := ioutil.TempFile(dir, "system-deploy-script-")
+	if err != nil {
+		return "", err
+	}
+
+	path := f.Name()
+	if err := a.fillScript(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (a *action) fillScript(f *os.File) error {
+	if _, err := f.WriteString(strings.Join(a.script, "\n") + "\n"); err != nil {
+		return err
+	}
+
+	if err := f.Chmod(0700); err != nil {
+		return err
+	}
+
+	if a.user != "" || a.group != "" {
+		uid, gid, err := resolveUserGroup(a.user, a.group)
+		if err != nil {
+			return err
+		}
+
+		if err := f.Chown(int(uid), int(gid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkGuards evaluates Creates=, Removes=, OnlyIf= and Unless=
+// and returns true if Command= should be executed.
+func (a *action) checkGuards(ctx context.Context) (bool, error) {
+	if a.creates != "" {
+		exists, err := a.exists(a.creates)
+		if err != nil {
+			return false, err
+		}
+
+		if exists {
+			a.Infof("skipping command, %s already exists", a.creates)
+			return false, nil
+		}
+		a.Debugf("%s does not exist", a.creates)
+	}
+
+	if a.removes != "" {
+		exists, err := a.exists(a.removes)
+		if err != nil {
+			return false, err
+		}
+
+		if !exists {
+			a.Infof("skipping command, %s does not exist", a.removes)
+			return false, nil
+		}
+		a.Debugf("%s exists", a.removes)
+	}
+
+	if a.onlyIf != "" {
+		code, err := a.probe(ctx, a.onlyIf)
+		if err != nil {
+			return false, fmt.Errorf("failed to run OnlyIf= command: %w", err)
+		}
+
+		if code != 0 {
+			a.Infof("skipping command, OnlyIf= command exited with %d", code)
+			return false, nil
+		}
+		a.Debugf("OnlyIf= command succeeded")
+	}
+
+	if a.unless != "" {
+		code, err := a.probe(ctx, a.unless)
+		if err != nil {
+			return false, fmt.Errorf("failed to run Unless= command: %w", err)
+		}
+
+		if code == 0 {
+			a.Infof("skipping command, Unless= command succeeded")
+			return false, nil
+		}
+		a.Debugf("Unless= command exited with %d", code)
+	}
+
+	return true, nil
+}
+
+// exists checks if path exists. Relative paths are resolved relative
+// to the working directory and all paths are resolved within the
+// chroot.
+func (a *action) exists(path string) (bool, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(a.taskDir, path)
+	}
+
+	if a.chroot != "" {
+		path = filepath.Join(a.chroot, path)
+	}
+
+	_, err := os.Lstat(path)
+	if err == nil {
+		return true, nil
+	}
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// probe executes cmd with the same settings as Command= but without
+// displaying its output and returns the exit code.
+func (a *action) probe(ctx context.Context, cmd string) (int64, error) {
+	var exitCode int64
+	opts, err := a.execOptions(&exitCode)
+	if err != nil {
+		return 0, err
+	}
+
+	opts.PipeInput = false
+	opts.PipeOutput = false
+
+	args, err := a.commandArgs(cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := utils.ExecArgs(ctx, a.taskDir, args, opts); err != nil {
+		if _, ok := err.(*utils.ExitCodeError); !ok {
+			return 0, err
+		}
+	}
+
+	return exitCode, nil
+}
+
+// execOptions returns the options to execute a command as configured.
+func (a *action) execOptions(exitCode *int64) (*utils.ExecOptions, error) {
 	opts := &utils.ExecOptions{
 		Attrs:      &syscall.SysProcAttr{},
 		PipeInput:  a.pipeIn,
 		PipeOutput: a.pipeOut,
 		Env:        a.environ,
-		ExitCode:   &exitCode,
+		CleanEnv:   a.cleanEnv,
+		ExitCode:   exitCode,
+		Sandbox:    a.sandbox,
 	}
 
 	hasAttrs := false
+	uid := currentUID()
 
 	if a.chroot != "" {
 		opts.Attrs.Chroot = a.chroot
+		hasAttrs = true
 	}
 
 	if a.user != "" || a.group != "" {
-		uid, gid, err := resolveUserGroup(a.user, a.group)
+		var (
+			gid uint32
+			err error
+		)
+		uid, gid, err = resolveUserGroup(a.user, a.group)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 
-		opts.Attrs.Credential = &syscall.Credential{
+		cred := &syscall.Credential{
 			Uid:         uid,
 			Gid:         gid,
 			NoSetGroups: true,
 		}
-		hasAttrs = true
-	}
 
-	if !hasAttrs {
-		opts.Attrs = nil
-	}
-
-	hasChanged := func() bool {
-		if a.exitCode != nil {
-			if *a.exitCode == exitCode {
-				return a.exitCodeChanged
+		if a.user != "" && a.supplementaryGroups {
+			acc, err := lookupAccount(a.chroot, uid)
+			if err != nil {
+				return nil, err
 			}
-			return !a.exitCodeChanged
+
+			cred.Groups = acc.groups
+			cred.NoSetGroups = false
 		}
 
-		return true
+		opts.Attrs.Credential = cred
+		hasAttrs = true
 	}
 
-	if err := utils.ExecCommand(ctx, a.taskDir, a.cmd, opts); err != nil {
-		if _, ok := err.(*utils.ExitCodeError); ok && a.exitCode != nil {
-			return hasChanged(), nil
+	if a.loginEnv {
+		acc, err := lookupAccount(a.chroot, uid)
+		if err != nil {
+			return nil, err
 		}
 
-		return hasChanged(), err
+		env, err := loginEnvironment(a.chroot, acc)
+		if err != nil {
+			return nil, err
+		}
+
+		// Environment= takes precedence.
+		for key, value := range a.environ {
+			env[key] = value
+		}
+		opts.Env = env
+	}
+
+	if !hasAttrs {
+		opts.Attrs = nil
 	}
 
-	return hasChanged(), nil
+	return opts, nil
 }
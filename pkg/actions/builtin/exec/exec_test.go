@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is re-executed as the sandbox helper.
+	utils.SandboxInit()
+
+	os.Exit(m.Run())
+}
+
+func TestScriptPrivateTmp(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("PrivateTmp= requires root")
+	}
+
+	f, err := ioutil.TempFile("/tmp", "exec")
+	require.NoError(t, err)
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	a := &action{
+		Base:        actions.Base{Logger: actions.NewLogger()},
+		script:      []string{"test ! -e " + f.Name()},
+		interpreter: []string{"/bin/sh"},
+		rules:       &changeRules{},
+		sandbox:     &utils.Sandbox{PrivateTmp: true},
+	}
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestScriptPrivateNetwork(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("PrivateNetwork= requires root")
+	}
+
+	// the loopback device must be up and the interpreter must be
+	// found without PATH.
+	a := &action{
+		Base:        actions.Base{Logger: actions.NewLogger()},
+		script:      []string{"grep -q 127.0.0.1 /proc/net/fib_trie"},
+		interpreter: []string{"sh"},
+		cleanEnv:    true,
+		rules:       &changeRules{},
+		sandbox:     &utils.Sandbox{PrivateNetwork: true},
+	}
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestScriptTask(t *testing.T) {
+	tsk, err := deploy.Decode("test.task", strings.NewReader(`[Exec]
+Script=set -- a b
+Script=test "$$1$$(echo $$2)" = ab
+`))
+	require.NoError(t, err)
+	require.NoError(t, deploy.ApplyEnvironment(tsk))
+
+	act, err := actions.Setup("Exec", actions.NewLogger(), *tsk, tsk.Sections[0])
+	require.NoError(t, err)
+
+	changed, err := act.(*action).Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils/passwd"
+)
+
+// Default values for PATH if /etc/login.defs does not define
+// ENV_PATH or ENV_SUPATH.
+const (
+	defaultPath     = "/usr/local/bin:/usr/bin:/bin"
+	defaultRootPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// account holds the information about a user that is required to
+// set up a login like environment.
+type account struct {
+	name  string
+	uid   int
+	home  string
+	shell string
+	// groups holds the primary and all supplementary groups.
+	groups []uint32
+}
+
+// lookupAccount reads the account with uid from /etc/passwd and
+// /etc/group below root.
+func lookupAccount(root string, uid uint32) (*account, error) {
+	pw, err := passwd.Load(filepath.Join(root, "/etc/passwd"), passwd.PasswdFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var acc *account
+	for _, entry := range pw.Entries() {
+		if entry[2] != strconv.FormatUint(uint64(uid), 10) {
+			continue
+		}
+
+		gid, err := strconv.ParseUint(entry[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid group ID for %s: %w", pw.Path(), entry[0], err)
+		}
+
+		acc = &account{
+			name:   entry[0],
+			uid:    int(uid),
+			home:   entry[5],
+			shell:  entry[6],
+			groups: []uint32{uint32(gid)},
+		}
+		break
+	}
+
+	if acc == nil {
+		return nil, fmt.Errorf("%s: no user with ID %d", pw.Path(), uid)
+	}
+
+	grp, err := passwd.Load(filepath.Join(root, "/etc/group"), passwd.GroupFields)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range grp.Entries() {
+		for _, member := range passwd.Members(entry[3]) {
+			if member != acc.name {
+				continue
+			}
+
+			gid, err := strconv.ParseUint(entry[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid group ID for %s: %w", grp.Path(), entry[0], err)
+			}
+
+			if gid != uint64(acc.groups[0]) {
+				acc.groups = append(acc.groups, uint32(gid))
+			}
+		}
+	}
+
+	return acc, nil
+}
+
+// loginEnvironment returns the environment variables a login shell
+// of acc would have.
+func loginEnvironment(root string, acc *account) (map[string]string, error) {
+	defs, err := passwd.LoadLoginDefs(filepath.Join(root, "/etc/login.defs"))
+	if err != nil {
+		return nil, err
+	}
+
+	key, path := "ENV_PATH", defaultPath
+	if acc.uid == 0 {
+		key, path = "ENV_SUPATH", defaultRootPath
+	}
+
+	if value, ok := defs[key]; ok {
+		path = strings.TrimPrefix(value, "PATH=")
+	}
+
+	shell := acc.shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	return map[string]string{
+		"HOME":    acc.home,
+		"USER":    acc.name,
+		"LOGNAME": acc.name,
+		"SHELL":   shell,
+		"PATH":    path,
+	}, nil
+}
+
+// currentUID returns the user ID of the current process.
+func currentUID() uint32 {
+	return uint32(os.Getuid())
+}
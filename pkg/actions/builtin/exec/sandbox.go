@@ -0,0 +1,146 @@
+package exec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+// sandboxOptions returns the options supported by parseSandbox.
+func sandboxOptions() []conf.OptionSpec {
+	options := []conf.OptionSpec{
+		{
+			Name:        "UMask",
+			Type:        conf.StringType,
+			Description: "The file mode creation mask (octal) of the command, for example `0027`.",
+		},
+		{
+			Name:        "Nice",
+			Type:        conf.IntType,
+			Description: "The nice level of the command between -20 (highest priority) and 19 (lowest priority).",
+		},
+		{
+			Name:        "PrivateNetwork",
+			Type:        conf.BoolType,
+			Default:     "no",
+			Description: "If set to yes, the command is executed in a new network namespace that only has a loopback device.",
+		},
+		{
+			Name:        "PrivateTmp",
+			Type:        conf.BoolType,
+			Default:     "no",
+			Description: "If set to yes, new and empty tmpfs file systems are mounted on /tmp and /var/tmp for the command. Script= is written to /run instead of /tmp then.",
+		},
+		{
+			Name:        "NoNewPrivileges",
+			Type:        conf.BoolType,
+			Default:     "no",
+			Description: "If set to yes, the command and its children can never gain new privileges, for example using setuid binaries.",
+		},
+		{
+			Name:        "CapabilityBoundingSet",
+			Type:        conf.StringSliceType,
+			Description: "A space separated list of capabilities to keep in the bounding set. If the list starts with `~` the listed capabilities are dropped instead. May be specified multiple times.",
+		},
+	}
+
+	for _, resource := range utils.RlimitResources {
+		options = append(options, conf.OptionSpec{
+			Name:        "Limit" + resource,
+			Type:        conf.StringType,
+			Description: fmt.Sprintf("The RLIMIT_%s resource limit of the command either as `soft:hard` or as a single value for both. Use `infinity` for no limit.", resource),
+		})
+	}
+
+	return options
+}
+
+// parseSandbox parses all sandbox options of sec. It returns nil
+// if none is set.
+func parseSandbox(sec conf.Section) (*utils.Sandbox, error) {
+	var (
+		s   utils.Sandbox
+		set bool
+	)
+
+	umask, err := sec.GetString("UMask")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+	if umask != "" {
+		value, err := strconv.ParseUint(umask, 8, 32)
+		if err != nil || value > 0777 {
+			return nil, fmt.Errorf("invalid value for UMask: %q", umask)
+		}
+
+		mask := int(value)
+		s.UMask = &mask
+		set = true
+	}
+
+	nice, err := sec.GetInt("Nice")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for Nice: %w", err)
+	}
+	if err == nil {
+		if nice < -20 || nice > 19 {
+			return nil, fmt.Errorf("invalid value for Nice: %d", nice)
+		}
+
+		value := int(nice)
+		s.Nice = &value
+		set = true
+	}
+
+	for _, resource := range utils.RlimitResources {
+		name := "Limit" + resource
+
+		value, err := sec.GetString(name)
+		if err != nil {
+			if conf.IsNotSet(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		limit, err := utils.ParseRlimit(resource, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+
+		s.Rlimits = append(s.Rlimits, limit)
+		set = true
+	}
+
+	for _, opt := range []struct {
+		name  string
+		value *bool
+	}{
+		{"PrivateNetwork", &s.PrivateNetwork},
+		{"PrivateTmp", &s.PrivateTmp},
+		{"NoNewPrivileges", &s.NoNewPrivileges},
+	} {
+		*opt.value, err = sec.GetBool(opt.name)
+		if err != nil && !conf.IsNotSet(err) {
+			return nil, fmt.Errorf("invalid value for %s: %w", opt.name, err)
+		}
+		set = set || *opt.value
+	}
+
+	if caps := sec.GetStringSlice("CapabilityBoundingSet"); len(caps) > 0 {
+		s.CapabilityBoundingSet, err = utils.ParseCapabilities(strings.Fields(strings.Join(caps, " ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for CapabilityBoundingSet: %w", err)
+		}
+		set = true
+	}
+
+	if !set {
+		return nil, nil
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,204 @@
+package exec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+)
+
+// exitRange is an inclusive range of exit codes.
+type exitRange struct {
+	from, to int
+}
+
+// exitStatusSet is a set of exit codes.
+type exitStatusSet []exitRange
+
+// parseExitStatus parses a list of exit codes separated by
+// whitespace. Each entry may be a single code like "2" or a range
+// like "2-5".
+func parseExitStatus(values []string) (exitStatusSet, error) {
+	var set exitStatusSet
+
+	for _, value := range values {
+		for _, field := range strings.Fields(value) {
+			from, to := field, field
+			if idx := strings.IndexByte(field, '-'); idx > 0 {
+				from, to = field[:idx], field[idx+1:]
+			}
+
+			r := exitRange{}
+			var err error
+			if r.from, err = parseExitCode(from); err != nil {
+				return nil, err
+			}
+			if r.to, err = parseExitCode(to); err != nil {
+				return nil, err
+			}
+
+			if r.from > r.to {
+				return nil, fmt.Errorf("invalid exit status range %q", field)
+			}
+
+			set = append(set, r)
+		}
+	}
+
+	return set, nil
+}
+
+func parseExitCode(value string) (int, error) {
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 0 || code > 255 {
+		return 0, fmt.Errorf("invalid exit status %q", value)
+	}
+
+	return code, nil
+}
+
+// contains returns true if code is part of set.
+func (set exitStatusSet) contains(code int) bool {
+	for _, r := range set {
+		if code >= r.from && code <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// single returns true if set contains exactly one exit code.
+func (set exitStatusSet) single() bool {
+	return len(set) == 1 && set[0].from == set[0].to
+}
+
+// overlaps returns true if any code is part of both sets.
+func (set exitStatusSet) overlaps(other exitStatusSet) bool {
+	for _, r := range set {
+		for _, o := range other {
+			if r.from <= o.to && o.from <= r.to {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// changeRules decides if a command has changed anything based on
+// its exit code and output.
+type changeRules struct {
+	success        exitStatusSet
+	changedOnExit  exitStatusSet
+	pristineOnExit exitStatusSet
+	changedOutput  *regexp.Regexp
+	pristineOutput *regexp.Regexp
+	// anyExit is set if ChangedOnExit= or PristineOnExit= is a
+	// single exit code. Like in earlier versions, no exit code is
+	// treated as failure then.
+	anyExit bool
+}
+
+// parseChangeRules parses ChangedOnExit=, PristineOnExit=,
+// SuccessExitStatus=, ChangedIfOutputMatches= and
+// PristineIfOutputMatches=.
+func parseChangeRules(sec conf.Section) (*changeRules, error) {
+	rules := &changeRules{}
+
+	for _, opt := range []struct {
+		name  string
+		value *exitStatusSet
+	}{
+		{"SuccessExitStatus", &rules.success},
+		{"ChangedOnExit", &rules.changedOnExit},
+		{"PristineOnExit", &rules.pristineOnExit},
+	} {
+		set, err := parseExitStatus(sec.GetStringSlice(opt.name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", opt.name, err)
+		}
+		*opt.value = set
+	}
+
+	if rules.changedOnExit.overlaps(rules.pristineOnExit) {
+		return nil, fmt.Errorf("ChangedOnExit and PristineOnExit must not share exit codes")
+	}
+
+	if len(rules.success) == 0 {
+		rules.anyExit = rules.changedOnExit.single() && len(rules.pristineOnExit) == 0 ||
+			rules.pristineOnExit.single() && len(rules.changedOnExit) == 0
+	}
+
+	for _, opt := range []struct {
+		name  string
+		value **regexp.Regexp
+	}{
+		{"ChangedIfOutputMatches", &rules.changedOutput},
+		{"PristineIfOutputMatches", &rules.pristineOutput},
+	} {
+		expr, err := sec.GetString(opt.name)
+		if err != nil {
+			if conf.IsNotSet(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		if *opt.value, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", opt.name, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// captureOutput returns true if the output of the command is
+// required to decide if it changed anything.
+func (r *changeRules) captureOutput() bool {
+	return r.changedOutput != nil || r.pristineOutput != nil
+}
+
+// configured returns true if any rule except SuccessExitStatus= is
+// set.
+func (r *changeRules) configured() bool {
+	return len(r.changedOnExit) > 0 ||
+		len(r.pristineOnExit) > 0 ||
+		r.captureOutput()
+}
+
+// successful returns true if code should not be treated as an
+// error.
+func (r *changeRules) successful(code int) bool {
+	return code == 0 ||
+		r.anyExit ||
+		r.success.contains(code) ||
+		r.changedOnExit.contains(code) ||
+		r.pristineOnExit.contains(code)
+}
+
+// decide returns true if a command that exited with code and
+// printed output changed anything, together with the reason for
+// the decision.
+func (r *changeRules) decide(code int, output []byte) (bool, string) {
+	switch {
+	case r.changedOnExit.contains(code):
+		return true, fmt.Sprintf("exit status %d matches ChangedOnExit=", code)
+	case r.pristineOnExit.contains(code):
+		return false, fmt.Sprintf("exit status %d matches PristineOnExit=", code)
+	case r.changedOutput != nil && r.changedOutput.Match(output):
+		return true, fmt.Sprintf("output matches ChangedIfOutputMatches=%s", r.changedOutput)
+	case r.pristineOutput != nil && r.pristineOutput.Match(output):
+		return false, fmt.Sprintf("output matches PristineIfOutputMatches=%s", r.pristineOutput)
+	}
+
+	if !r.configured() {
+		return true, fmt.Sprintf("exit status %d", code)
+	}
+
+	// If only rules for changes are configured, everything else is
+	// pristine and vice versa.
+	hasPristine := len(r.pristineOnExit) > 0 || r.pristineOutput != nil
+
+	return hasPristine, fmt.Sprintf("exit status %d and output match no rule", code)
+}
@@ -0,0 +1,116 @@
+package exec
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExitStatus(t *testing.T) {
+	set, err := parseExitStatus([]string{"2 3", "10-20"})
+	assert.NoError(t, err)
+	assert.Equal(t, exitStatusSet{{2, 2}, {3, 3}, {10, 20}}, set)
+
+	assert.True(t, set.contains(15))
+	assert.False(t, set.contains(4))
+	assert.True(t, set.overlaps(exitStatusSet{{20, 30}}))
+	assert.False(t, set.overlaps(exitStatusSet{{4, 9}}))
+
+	for _, value := range []string{"x", "-1", "256", "5-2", "1-"} {
+		_, err := parseExitStatus([]string{value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestChangeRulesDecide(t *testing.T) {
+	cases := []struct {
+		name    string
+		rules   changeRules
+		code    int
+		output  string
+		changed bool
+	}{
+		{
+			name:    "default",
+			changed: true,
+		},
+		{
+			name:    "changed on exit",
+			rules:   changeRules{changedOnExit: exitStatusSet{{2, 3}}},
+			code:    3,
+			changed: true,
+		},
+		{
+			name:  "no changed rule matches",
+			rules: changeRules{changedOnExit: exitStatusSet{{2, 3}}},
+		},
+		{
+			name:    "no pristine rule matches",
+			rules:   changeRules{pristineOnExit: exitStatusSet{{1, 1}}},
+			changed: true,
+		},
+		{
+			name: "exit code takes precedence",
+			rules: changeRules{
+				pristineOnExit: exitStatusSet{{0, 0}},
+				changedOutput:  regexp.MustCompile("updated"),
+			},
+			output: "updated",
+		},
+		{
+			name:    "output matches",
+			rules:   changeRules{changedOutput: regexp.MustCompile(`(?m)^installed `)},
+			output:  "foo\ninstalled bar\n",
+			changed: true,
+		},
+		{
+			name:   "pristine output matches",
+			rules:  changeRules{pristineOutput: regexp.MustCompile("up to date")},
+			output: "Already up to date.",
+		},
+	}
+
+	for _, c := range cases {
+		changed, _ := c.rules.decide(c.code, []byte(c.output))
+		assert.Equal(t, c.changed, changed, c.name)
+	}
+}
+
+func TestChangeRulesSuccessful(t *testing.T) {
+	cases := []struct {
+		name       string
+		options    map[string]string
+		code       int
+		successful bool
+	}{
+		{"default", nil, 1, false},
+		{"success exit status", map[string]string{"SuccessExitStatus": "1-3"}, 2, true},
+		{"listed", map[string]string{"ChangedOnExit": "2 3"}, 3, true},
+		{"not listed", map[string]string{"ChangedOnExit": "2 3"}, 1, false},
+		{"range not listed", map[string]string{"PristineOnExit": "2-3"}, 1, false},
+		// a single code keeps the behavior of earlier versions.
+		{"single pristine code", map[string]string{"PristineOnExit": "0"}, 1, true},
+		{"single changed code", map[string]string{"ChangedOnExit": "2"}, 1, true},
+		{"single code and success exit status", map[string]string{"ChangedOnExit": "2", "SuccessExitStatus": "3"}, 1, false},
+	}
+
+	for _, c := range cases {
+		sec := conf.Section{Name: "Exec"}
+		for name, value := range c.options {
+			sec.Options = append(sec.Options, conf.Option{Name: name, Value: value})
+		}
+
+		rules, err := parseChangeRules(sec)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.successful, rules.successful(c.code), c.name)
+	}
+
+	rules, err := parseChangeRules(conf.Section{Options: conf.Options{{Name: "PristineOnExit", Value: "0"}}})
+	require.NoError(t, err)
+
+	changed, _ := rules.decide(1, nil)
+	assert.True(t, changed)
+}
@@ -0,0 +1,350 @@
+package extract
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/change"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "Extract",
+		Description: "Extract tar and zip archives",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Supported Formats",
+				Description: "" +
+					"Uncompressed tar archives as well as tar archives compressed with gzip (`.tar.gz`, `.tgz`) or bzip2 (`.tar.bz2`, `.tbz2`) " +
+					"and zip archives are supported. The format is detected by the file extension.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"If Creates= is set, the archive is only extracted if that path does not exist. " +
+					"Otherwise the SHA-256 checksum of the archive is stored in the extended attribute `user.system-deploy.extract.<name>` " +
+					"of Destination after a successful extraction and the archive is only extracted again if the checksum changes. " +
+					"If Destination does not support extended attributes the archive is extracted every time. " +
+					"The task is only marked as changed if the archive has actually been extracted.",
+			},
+			{
+				Title: "Security",
+				Description: "" +
+					"Entries that would be extracted outside of Destination (for example `../../etc/passwd`), absolute paths and " +
+					"symbolic or hard links pointing outside of Destination are rejected and abort the extraction. " +
+					"Link targets are resolved like the kernel would, including symbolic links extracted earlier, " +
+					"and entries are never written through symbolic links. Hard links may only point to regular files.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Archive",
+				Description: "The path to the archive. Relative paths are resolved relative to the task directory.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Destination",
+				Description: "The directory to extract the archive to. It is created if it does not exist.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "StripComponents",
+				Description: "Strip the given number of leading path components from each entry. Entries with less components are skipped.",
+				Type:        conf.IntType,
+				Default:     "0",
+			},
+			{
+				Name: "Include",
+				Description: "" +
+					"Only extract entries matching the glob pattern. Patterns containing a path separator are matched against the path " +
+					"(after StripComponents=) while all other patterns are matched against the file name only. May be specified multiple times.",
+				Type: conf.StringSliceType,
+			},
+			{
+				Name:        "Exclude",
+				Description: "Do not extract entries matching the glob pattern. See Include= for the syntax. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "Owner",
+				Description: "The owner (name or ID) of all extracted files and directories. Defaults to the user running system-deploy.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Group",
+				Description: "The group (name or ID) of all extracted files and directories. Defaults to the group of the user running system-deploy.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Creates",
+				Description: "A path that is created by the extraction. If it exists the archive is not extracted.",
+				Type:        conf.StringType,
+			},
+		},
+	})
+}
+
+// checksumAttrPrefix is the prefix of the extended attribute that
+// stores the checksum of the last extracted archive.
+const checksumAttrPrefix = "user.system-deploy.extract."
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	archive, err := sec.GetString("Archive")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(archive) {
+		archive = filepath.Join(task.Directory, archive)
+	}
+
+	dest, err := sec.GetString("Destination")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(dest) {
+		return nil, fmt.Errorf("Destination must be absolute: %q", dest)
+	}
+
+	a := &action{
+		archive:     filepath.Clean(archive),
+		destination: filepath.Clean(dest),
+		include:     sec.GetStringSlice("Include"),
+		exclude:     sec.GetStringSlice("Exclude"),
+	}
+
+	if a.format = detectFormat(a.archive); a.format == "" {
+		return nil, fmt.Errorf("unsupported archive format: %s", a.archive)
+	}
+
+	strip, err := sec.GetInt("StripComponents")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for StripComponents: %w", err)
+	}
+	if strip < 0 {
+		return nil, fmt.Errorf("invalid value for StripComponents: %d", strip)
+	}
+	a.strip = int(strip)
+
+	for _, pattern := range append(append([]string(nil), a.include...), a.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	if a.owner, err = sec.GetString("Owner"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	if a.group, err = sec.GetString("Group"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	if a.creates, err = sec.GetString("Creates"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+type action struct {
+	actions.Base
+
+	archive     string
+	format      string
+	destination string
+	strip       int
+	include     []string
+	exclude     []string
+	owner       string
+	group       string
+	creates     string
+}
+
+func (a *action) Name() string {
+	return "Extract " + a.archive + " to " + a.destination
+}
+
+func (a *action) Execute(ctx context.Context) (bool, error) {
+	if a.creates != "" {
+		if _, err := os.Lstat(a.creates); err == nil {
+			a.Debugf("%s already exists", a.creates)
+			return false, nil
+		}
+	}
+
+	sum, err := fileChecksum(a.archive)
+	if err != nil {
+		return false, err
+	}
+
+	attr := checksumAttrPrefix + filepath.Base(a.archive)
+	if a.creates == "" {
+		if current, err := utils.GetXattr(a.destination, attr); err == nil && string(current) == sum {
+			a.Debugf("%s has already been extracted", a.archive)
+			return false, nil
+		}
+	}
+
+	uid, gid, err := utils.LookupOwner(a.owner, a.group)
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.MkdirAll(a.destination, 0755); err != nil {
+		return false, err
+	}
+
+	x := &extractor{
+		action: a,
+		uid:    uid,
+		gid:    gid,
+	}
+
+	if err := x.extract(ctx); err != nil {
+		return true, fmt.Errorf("failed to extract %s: %w", a.archive, err)
+	}
+
+	if a.creates == "" {
+		if err := utils.SetXattr(a.destination, attr, []byte(sum)); err != nil {
+			a.Warnf("failed to store archive checksum, %s will be extracted again: %s", a.archive, err)
+		}
+	}
+
+	return true, nil
+}
+
+// targetPath returns the path below the destination for the archive
+// entry name. It returns an empty string if the entry should be
+// skipped and an error if the entry would be extracted outside of
+// the destination.
+func (a *action) targetPath(name string) (string, error) {
+	name = filepath.ToSlash(name)
+
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%s: absolute paths are not allowed", name)
+	}
+
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	for _, p := range parts {
+		if p == ".." {
+			return "", fmt.Errorf("%s: path traversal is not allowed", name)
+		}
+	}
+
+	// drop "." components so they don't count for StripComponents=.
+	var clean []string
+	for _, p := range parts {
+		if p != "." {
+			clean = append(clean, p)
+		}
+	}
+
+	if len(clean) <= a.strip {
+		return "", nil
+	}
+
+	rel := filepath.Join(clean[a.strip:]...)
+	if !a.matches(rel) {
+		return "", nil
+	}
+
+	target := filepath.Join(a.destination, rel)
+	if !a.within(target) {
+		return "", fmt.Errorf("%s: outside of destination", name)
+	}
+
+	return target, nil
+}
+
+// within returns true if path is inside the destination directory.
+func (a *action) within(path string) bool {
+	rel, err := filepath.Rel(a.destination, path)
+	if err != nil || rel == "." {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// matches checks rel against Include= and Exclude=.
+func (a *action) matches(rel string) bool {
+	if len(a.include) > 0 && !matchAny(a.include, rel) {
+		return false
+	}
+
+	return !matchAny(a.exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := filepath.Base(rel)
+		if strings.Contains(pattern, string(filepath.Separator)) {
+			name = rel
+		}
+
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+
+		// a pattern matching a directory also matches
+		// everything below it.
+		if strings.Contains(pattern, string(filepath.Separator)) {
+			for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+				if ok, _ := filepath.Match(pattern, dir); ok {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// fileChecksum returns the hex encoded SHA-256 checksum of path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ensureOwner is like change.EnsureFileOwner but ignores the result.
+func ensureOwner(path string, uid, gid int) error {
+	_, err := change.EnsureFileOwner(path, uid, gid)
+	return err
+}
+
+const example = `[Task]
+Description= Install the Go toolchain
+
+[Extract]
+Archive=/var/cache/downloads/go1.14.4.linux-amd64.tar.gz
+Destination=/usr/local
+Creates=/usr/local/go/bin/go
+`
@@ -0,0 +1,190 @@
+package extract
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+type tarEntry struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+func writeTarGz(t *testing.T, path string, entries []tarEntry) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func newTestAction(t *testing.T, entries []tarEntry) *action {
+	dir, err := ioutil.TempDir("", "extract")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	archive := filepath.Join(dir, "archive.tar.gz")
+	writeTarGz(t, archive, entries)
+
+	return &action{
+		Base:        actions.Base{Logger: actions.NewLogger()},
+		archive:     archive,
+		format:      detectFormat(archive),
+		destination: filepath.Join(dir, "dest"),
+	}
+}
+
+func TestExtract(t *testing.T) {
+	a := newTestAction(t, []tarEntry{
+		{name: "tool-1.0/", typeflag: tar.TypeDir},
+		{name: "tool-1.0/bin/tool", content: "binary", typeflag: tar.TypeReg},
+		{name: "tool-1.0/README", content: "readme", typeflag: tar.TypeReg},
+		{name: "tool-1.0/bin/alias", typeflag: tar.TypeSymlink, linkname: "tool"},
+	})
+	a.strip = 1
+	a.exclude = []string{"README"}
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := ioutil.ReadFile(filepath.Join(a.destination, "bin", "alias"))
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(data))
+
+	_, err = os.Stat(filepath.Join(a.destination, "README"))
+	assert.True(t, os.IsNotExist(err))
+
+	// the archive checksum is stored so a second run must not
+	// extract the archive again.
+	if _, err := utils.GetXattr(a.destination, checksumAttrPrefix+"archive.tar.gz"); err != nil {
+		t.Skipf("extended attributes not supported: %s", err)
+	}
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestExtractCreates(t *testing.T) {
+	a := newTestAction(t, []tarEntry{
+		{name: "file", content: "content", typeflag: tar.TypeReg},
+	})
+	a.creates = filepath.Join(a.destination, "file")
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestExtractPathTraversal(t *testing.T) {
+	cases := [][]tarEntry{
+		{{name: "../evil", content: "x", typeflag: tar.TypeReg}},
+		{{name: "a/../../evil", content: "x", typeflag: tar.TypeReg}},
+		{{name: "/etc/evil", content: "x", typeflag: tar.TypeReg}},
+		{{name: "link", typeflag: tar.TypeSymlink, linkname: "../../"}},
+		{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+		{{name: "hard", typeflag: tar.TypeLink, linkname: "../evil"}},
+		// chained symbolic links.
+		{
+			{name: "d/", typeflag: tar.TypeDir},
+			{name: "d/l", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "d/m", typeflag: tar.TypeSymlink, linkname: "l/../evil"},
+		},
+		// hard link through a symbolic link.
+		{
+			{name: "d/", typeflag: tar.TypeDir},
+			{name: "d/l", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "d/m", typeflag: tar.TypeSymlink, linkname: "l/../evil"},
+			{name: "h", typeflag: tar.TypeLink, linkname: "d/m/secret"},
+		},
+		// a later entry changes what an earlier link resolves to.
+		{
+			{name: "d/", typeflag: tar.TypeDir},
+			{name: "d/l", typeflag: tar.TypeSymlink, linkname: "x/../../evil"},
+			{name: "d/x", typeflag: tar.TypeSymlink, linkname: "."},
+		},
+	}
+
+	for _, entries := range cases {
+		a := newTestAction(t, entries)
+
+		_, err := a.Execute(context.Background())
+		assert.Error(t, err, entries[0].name)
+
+		_, err = os.Lstat(filepath.Join(filepath.Dir(a.destination), "evil"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// hard links must not be created through existing symbolic links.
+	a := newTestAction(t, []tarEntry{
+		{name: "h", typeflag: tar.TypeLink, linkname: "d/secret"},
+	})
+	outside := filepath.Dir(a.destination)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600))
+	require.NoError(t, os.MkdirAll(a.destination, 0755))
+	require.NoError(t, os.Symlink("..", filepath.Join(a.destination, "d")))
+
+	_, err := a.Execute(context.Background())
+	assert.Error(t, err)
+
+	_, err = os.Lstat(filepath.Join(a.destination, "h"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWithin(t *testing.T) {
+	a := &action{destination: "/srv"}
+	assert.True(t, a.within("/srv/www"))
+	assert.True(t, a.within("/srv/..data"))
+	assert.False(t, a.within("/srv"))
+	assert.False(t, a.within("/srvx"))
+	assert.False(t, a.within("/srv/../etc"))
+
+	// extracting to the root directory must work.
+	a.destination = "/"
+	assert.True(t, a.within("/usr/bin/tool"))
+	assert.False(t, a.within("/"))
+}
@@ -0,0 +1,444 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+// Supported archive formats.
+const (
+	formatTar      = "tar"
+	formatTarGzip  = "tar.gz"
+	formatTarBzip2 = "tar.bz2"
+	formatZip      = "zip"
+)
+
+// detectFormat returns the archive format of path based on the
+// file extension or an empty string if the format is not supported.
+func detectFormat(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return formatTarGzip
+	case strings.HasSuffix(name, ".tar.bz2"), strings.HasSuffix(name, ".tbz2"):
+		return formatTarBzip2
+	case strings.HasSuffix(name, ".tar"):
+		return formatTar
+	case strings.HasSuffix(name, ".zip"):
+		return formatZip
+	}
+
+	return ""
+}
+
+// extractor extracts a single archive.
+type extractor struct {
+	*action
+
+	uid int
+	gid int
+
+	// symlinks holds all symbolic links created by the extractor.
+	symlinks []string
+}
+
+// maxSymlinks is the maximum number of symbolic links followed when
+// resolving a path.
+const maxSymlinks = 40
+
+func (x *extractor) extract(ctx context.Context) error {
+	if err := x.extractEntries(ctx); err != nil {
+		return err
+	}
+
+	// a later archive entry might have changed what an earlier
+	// symbolic link resolves to, so check all of them again.
+	for _, link := range x.symlinks {
+		linkname, err := os.Readlink(link)
+		if err != nil {
+			return err
+		}
+
+		if _, err := x.resolve(filepath.Dir(link), linkname); err != nil {
+			if rmErr := os.Remove(link); rmErr != nil {
+				x.Warnf("failed to remove %s: %s", link, rmErr)
+			}
+			return fmt.Errorf("%s: %w", link, err)
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) extractEntries(ctx context.Context) error {
+	if x.format == formatZip {
+		return x.extractZip(ctx)
+	}
+
+	f, err := os.Open(x.archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch x.format {
+	case formatTarGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case formatTarBzip2:
+		r = bzip2.NewReader(f)
+	}
+
+	return x.extractTar(ctx, tar.NewReader(r))
+}
+
+func (x *extractor) extractTar(ctx context.Context, tr *tar.Reader) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := x.targetPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.writeDir(target, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.writeFile(target, mode, tr)
+		case tar.TypeSymlink:
+			err = x.writeSymlink(target, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.writeHardlink(target, hdr.Linkname)
+		default:
+			x.Debugf("skipping unsupported entry %s", hdr.Name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func (x *extractor) extractZip(ctx context.Context) error {
+	zr, err := zip.OpenReader(x.archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		target, err := x.targetPath(f.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+
+		if err := x.extractZipEntry(f, target); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) extractZipEntry(f *zip.File, target string) error {
+	info := f.FileInfo()
+
+	if info.IsDir() {
+		return x.writeDir(target, info.Mode().Perm())
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		linkname, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return x.writeSymlink(target, string(linkname))
+	}
+
+	if !info.Mode().IsRegular() {
+		x.Debugf("skipping unsupported entry %s", f.Name)
+		return nil
+	}
+
+	return x.writeFile(target, info.Mode().Perm(), rc)
+}
+
+// prepareParent creates all missing parent directories of target
+// and makes sure none of the existing ones is a symbolic link so
+// a previous archive entry cannot redirect the extraction to a
+// location outside of the destination.
+func (x *extractor) prepareParent(target string) error {
+	missing, err := x.checkParents(target)
+	if err != nil {
+		return err
+	}
+
+	for idx := len(missing) - 1; idx >= 0; idx-- {
+		if err := os.Mkdir(missing[idx], 0755); err != nil {
+			return err
+		}
+
+		if err := ensureOwner(missing[idx], x.uid, x.gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkParents makes sure that none of the existing parent
+// directories of path below the destination is a symbolic link. It
+// returns all missing parent directories, the deepest one first.
+func (x *extractor) checkParents(path string) ([]string, error) {
+	var missing []string
+
+	for dir := filepath.Dir(path); dir != x.destination; dir = filepath.Dir(dir) {
+		stat, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			missing = append(missing, dir)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if stat.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("refusing to extract through symbolic link %s", dir)
+		}
+
+		if !stat.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+
+	return missing, nil
+}
+
+// resolve resolves the relative path name starting at dir (which
+// must be the destination or a directory below it) component by
+// component like the kernel would. Symbolic links are followed.
+// Components that do not exist yet are resolved lexically. It
+// returns an error if any step leaves the destination.
+func (x *extractor) resolve(dir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("symbolic link to absolute path %q is not allowed", name)
+	}
+
+	rel, err := filepath.Rel(x.destination, dir)
+	if err != nil {
+		return "", err
+	}
+
+	sep := string(filepath.Separator)
+	pending := append(strings.Split(rel, sep), strings.Split(name, sep)...)
+	current := x.destination
+	links := 0
+
+	for len(pending) > 0 {
+		component := pending[0]
+		pending = pending[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if current == x.destination {
+				return "", fmt.Errorf("%q points outside of destination", name)
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, component)
+
+		stat, err := os.Lstat(next)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%q: too many levels of symbolic links", name)
+		}
+
+		linkname, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(linkname) {
+			return "", fmt.Errorf("%q resolves through symbolic link to absolute path %q", name, linkname)
+		}
+
+		// the link target is relative to the directory containing
+		// the link, which is current.
+		pending = append(strings.Split(linkname, sep), pending...)
+	}
+
+	return current, nil
+}
+
+// removeExisting removes target if it exists and is not a
+// directory.
+func removeExisting(target string) error {
+	stat, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if stat.IsDir() {
+		return fmt.Errorf("%s exists but is a directory", target)
+	}
+
+	return os.Remove(target)
+}
+
+func (x *extractor) writeDir(target string, mode os.FileMode) error {
+	if err := x.prepareParent(target); err != nil {
+		return err
+	}
+
+	stat, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Mkdir(target, mode|0700); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !stat.IsDir():
+		return fmt.Errorf("%s exists but is not a directory", target)
+	}
+
+	return ensureOwner(target, x.uid, x.gid)
+}
+
+func (x *extractor) writeFile(target string, mode os.FileMode, r io.Reader) error {
+	if err := x.prepareParent(target); err != nil {
+		return err
+	}
+
+	// never write through an existing symbolic link.
+	if stat, err := os.Lstat(target); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	return utils.CreateAtomicOwner(target, mode, x.uid, x.gid, r, nil)
+}
+
+func (x *extractor) writeSymlink(target, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("symbolic link to absolute path %q is not allowed", linkname)
+	}
+
+	if err := x.prepareParent(target); err != nil {
+		return err
+	}
+
+	if _, err := x.resolve(filepath.Dir(target), linkname); err != nil {
+		return fmt.Errorf("symbolic link: %w", err)
+	}
+
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.symlinks = append(x.symlinks, target)
+
+	return ensureOwner(target, x.uid, x.gid)
+}
+
+func (x *extractor) writeHardlink(target, linkname string) error {
+	source, err := x.targetPath(linkname)
+	if err != nil {
+		return err
+	}
+
+	if source == "" {
+		return fmt.Errorf("hard link to %q which is not extracted", linkname)
+	}
+
+	// the source must physically be below the destination.
+	if _, err := x.checkParents(source); err != nil {
+		return err
+	}
+
+	// link(2) does not follow symbolic links so the new link would
+	// be a copy of the symbolic link in a different directory.
+	stat, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("hard link to %q which is not a regular file", linkname)
+	}
+
+	if err := x.prepareParent(target); err != nil {
+		return err
+	}
+
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	return os.Link(source, target)
+}
@@ -0,0 +1,397 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/change"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "File",
+		Description: "Manage the state, content and permissions of files and directories",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "States",
+				Description: "" +
+					"`file` ensures Path= is a regular file. If Content= is set the file content is replaced, otherwise a missing file is created empty. " +
+					"`directory` ensures Path= is a directory and creates it including all parents if required. " +
+					"`absent` removes Path=. Non-empty directories are only removed if Recursive=yes. " +
+					"`touch` creates Path= if it does not exist and updates the access and modification times otherwise. " +
+					"Updating the timestamps alone does not mark the task as changed.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"The content of Path= is compared with Content= and only replaced (atomically) if it differs. " +
+					"Mode=, Owner= and Group= are applied even if the content is already correct. " +
+					"If Owner= or Group= are not set the ownership of an existing file is kept.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Path",
+				Description: "The path of the file or directory to manage.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name: "Content",
+				Description: "" +
+					"The content of the file. May be specified multiple times in which case each value is written as a separate line. " +
+					"Environment variables are substituted like for all other options.",
+				Type: conf.StringSliceType,
+			},
+			{
+				Name:        "State",
+				Description: "The expected state of Path=. One of `file`, `directory`, `absent` or `touch`.",
+				Type:        conf.StringType,
+				Default:     stateFile,
+			},
+			{
+				Name:        "Mode",
+				Description: "The mode bits for Path=. If unset, new files are created with 0644 and new directories with 0755 while existing ones are not changed.",
+				Type:        conf.IntType,
+			},
+			{
+				Name:        "Owner",
+				Description: "The owner of Path= (either name or ID).",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Group",
+				Description: "The group of Path= (either name or ID).",
+				Type:        conf.StringType,
+			},
+			{
+				Name: "Recursive",
+				Description: "" +
+					"For directories, apply Owner= and Group= to all files and directories below Path= and Mode= to all sub-directories. " +
+					"For State=absent, allow removal of non-empty directories.",
+				Type:    conf.BoolType,
+				Default: "no",
+			},
+		},
+	})
+}
+
+// Supported values for the State= option.
+const (
+	stateFile      = "file"
+	stateDirectory = "directory"
+	stateAbsent    = "absent"
+	stateTouch     = "touch"
+)
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	path, err := sec.GetString("Path")
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Clean(path)
+
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("Path= must be absolute: %q", path)
+	}
+
+	state, err := sec.GetString("State")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		state = stateFile
+	}
+
+	switch state {
+	case stateFile, stateDirectory, stateAbsent, stateTouch:
+	default:
+		return nil, fmt.Errorf("invalid value for State: %q", state)
+	}
+
+	a := &action{
+		path:  path,
+		state: state,
+	}
+
+	if content := sec.GetStringSlice("Content"); content != nil {
+		if state != stateFile {
+			return nil, fmt.Errorf("Content= is only supported with State=%s", stateFile)
+		}
+
+		c := strings.Join(content, "\n") + "\n"
+		a.content = &c
+	}
+
+	mode, err := sec.GetInt("Mode")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, fmt.Errorf("invalid value for Mode: %w", err)
+		}
+	} else {
+		if mode < 0 || mode > 07777 {
+			return nil, fmt.Errorf("invalid value for Mode: %o", mode)
+		}
+		a.mode = os.FileMode(mode)
+		a.modeSet = true
+	}
+
+	a.owner, err = sec.GetString("Owner")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	a.group, err = sec.GetString("Group")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	a.recursive, err = sec.GetBool("Recursive")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for Recursive: %w", err)
+	}
+
+	return a, nil
+}
+
+type action struct {
+	actions.Base
+
+	path      string
+	state     string
+	content   *string
+	mode      os.FileMode
+	modeSet   bool
+	owner     string
+	group     string
+	recursive bool
+}
+
+func (a *action) Name() string {
+	return "File " + a.path
+}
+
+func (a *action) Execute(_ context.Context) (bool, error) {
+	if a.state == stateAbsent {
+		return a.remove()
+	}
+
+	uid, gid, err := utils.LookupOwner(a.owner, a.group)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	switch a.state {
+	case stateDirectory:
+		changed, err = a.ensureDirectory()
+	case stateTouch:
+		changed, err = a.touch()
+	default:
+		changed, err = a.ensureFile()
+	}
+	if err != nil {
+		return changed, err
+	}
+
+	updated, err := a.ensurePermissions(a.path, uid, gid, true)
+	if err != nil {
+		return changed, err
+	}
+	changed = changed || updated
+
+	if a.state == stateDirectory && a.recursive {
+		err := filepath.Walk(a.path, func(path string, info os.FileInfo, err error) error {
+			if err != nil || path == a.path {
+				return err
+			}
+
+			updated, err := a.ensurePermissions(path, uid, gid, info.IsDir())
+			changed = changed || updated
+			return err
+		})
+		if err != nil {
+			return changed, err
+		}
+	}
+
+	return changed, nil
+}
+
+// ensureFile makes sure a.path is a regular file and has the
+// expected content. The owner of an existing file is preserved
+// by utils.UpdateAtomic.
+func (a *action) ensureFile() (bool, error) {
+	stat, err := os.Lstat(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	if exists && !stat.Mode().IsRegular() {
+		return false, fmt.Errorf("%s exists but is not a regular file", a.path)
+	}
+
+	if exists && a.content == nil {
+		return false, nil
+	}
+
+	mode := os.FileMode(0644)
+	if a.modeSet {
+		mode = a.mode
+	} else if exists {
+		// keep setuid, setgid and sticky bits as well.
+		mode = stat.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+
+	content := ""
+	if a.content != nil {
+		content = *a.content
+	}
+
+	return utils.UpdateAtomic(a.path, mode, []byte(content))
+}
+
+// ensureDirectory makes sure a.path is a directory.
+func (a *action) ensureDirectory() (bool, error) {
+	stat, err := os.Lstat(a.path)
+	if err == nil {
+		if !stat.IsDir() {
+			return false, fmt.Errorf("%s exists but is not a directory", a.path)
+		}
+		return false, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	mode := os.FileMode(0755)
+	if a.modeSet {
+		mode = a.mode
+	}
+
+	if err := os.MkdirAll(a.path, mode); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// touch creates a.path if it does not exist and updates the
+// access and modification times otherwise.
+func (a *action) touch() (bool, error) {
+	stat, err := os.Lstat(a.path)
+	if err == nil {
+		if stat.IsDir() {
+			return false, fmt.Errorf("%s exists but is a directory", a.path)
+		}
+
+		now := time.Now()
+		return false, os.Chtimes(a.path, now, now)
+	}
+
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	mode := os.FileMode(0644)
+	if a.modeSet {
+		mode = a.mode
+	}
+
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return false, err
+	}
+
+	return true, f.Close()
+}
+
+// remove removes a.path.
+func (a *action) remove() (bool, error) {
+	stat, err := os.Lstat(a.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if a.path == "/" {
+		return false, fmt.Errorf("refusing to remove /")
+	}
+
+	if stat.IsDir() && a.recursive {
+		return true, os.RemoveAll(a.path)
+	}
+
+	return true, os.Remove(a.path)
+}
+
+// ensurePermissions applies Mode=, Owner= and Group= to path. The
+// mode is only applied if applyMode is true.
+func (a *action) ensurePermissions(path string, uid, gid int, applyMode bool) (bool, error) {
+	var changed bool
+
+	if a.modeSet && applyMode {
+		stat, err := os.Lstat(path)
+		if err != nil {
+			return false, err
+		}
+
+		mode := stat.Mode()&^(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) | toFileMode(a.mode)
+		if mode != stat.Mode() {
+			if err := os.Chmod(path, mode); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+
+	updated, err := change.EnsureFileOwner(path, uid, gid)
+	if err != nil {
+		return changed, err
+	}
+
+	return changed || updated, nil
+}
+
+// toFileMode converts the unix mode bits in m to os.FileMode.
+func toFileMode(m os.FileMode) os.FileMode {
+	mode := m & os.ModePerm
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+const example = `[Task]
+Description= Manage the message of the day
+
+[File]
+Path=/etc/motd
+Content=Welcome to ${HOSTNAME}!
+Content=This system is managed by system-deploy.
+Mode=0644
+Owner=root
+Group=root
+`
@@ -0,0 +1,140 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+func newTestAction(t *testing.T, state string) *action {
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return &action{
+		Base:  actions.Base{Logger: actions.NewLogger()},
+		path:  filepath.Join(dir, "target"),
+		state: state,
+	}
+}
+
+func TestFileIdempotent(t *testing.T) {
+	content := "hello\n"
+
+	a := newTestAction(t, stateFile)
+	a.content = &content
+	a.mode = 0640
+	a.modeSet = true
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// content drift
+	require.NoError(t, ioutil.WriteFile(a.path, []byte("modified\n"), 0640))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := ioutil.ReadFile(a.path)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// mode drift
+	require.NoError(t, os.Chmod(a.path, 0666))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	stat, err := os.Stat(a.path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm())
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestFileKeepSpecialBits(t *testing.T) {
+	content := "#!/bin/sh\n"
+
+	a := newTestAction(t, stateFile)
+	a.content = &content
+
+	require.NoError(t, ioutil.WriteFile(a.path, []byte("old\n"), 0755))
+	require.NoError(t, os.Chmod(a.path, 0755|os.ModeSetuid|os.ModeSetgid))
+
+	// without Mode= the setuid and setgid bits are kept when the
+	// content is replaced.
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	stat, err := os.Stat(a.path)
+	require.NoError(t, err)
+	assert.Equal(t, 0755|os.ModeSetuid|os.ModeSetgid, stat.Mode())
+}
+
+func TestDirectoryIdempotent(t *testing.T) {
+	a := newTestAction(t, stateDirectory)
+	a.mode = 02750
+	a.modeSet = true
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	stat, err := os.Stat(a.path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|os.ModeSetgid|0750, stat.Mode())
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestFileOwnerDrift(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner requires root")
+	}
+
+	content := "hello\n"
+
+	a := newTestAction(t, stateFile)
+	a.content = &content
+	a.owner = "0"
+	a.group = "0"
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	require.NoError(t, os.Chown(a.path, 1000, 1000))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	stat, err := os.Stat(a.path)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), stat.Sys().(*syscall.Stat_t).Uid)
+	assert.Equal(t, uint32(0), stat.Sys().(*syscall.Stat_t).Gid)
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
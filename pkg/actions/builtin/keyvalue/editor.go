@@ -0,0 +1,188 @@
+package keyvalue
+
+import (
+	"strings"
+)
+
+// editor updates a key in a line based configuration file.
+type editor struct {
+	// key is the name of the key to update.
+	key string
+	// value is the expected value of key.
+	value string
+	// section is the INI section that should contain key. An
+	// empty section refers to everything before the first
+	// section header.
+	section string
+	// separator separates the key from the value. A single
+	// space means any whitespace.
+	separator string
+	// absent is set if key should be removed.
+	absent bool
+}
+
+// commentPrefixes holds all supported comment prefixes.
+const commentPrefixes = "#;"
+
+// sectionName returns the name of the INI section started by line
+// and true. If line is not a section header false is returned.
+func sectionName(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || line[0] != '[' || line[len(line)-1] != ']' {
+		return "", false
+	}
+
+	return strings.TrimSpace(line[1 : len(line)-1]), true
+}
+
+// parse checks if line (without comment prefixes) assigns ed.key.
+// It returns everything up to the value, including the key, the
+// separator and any whitespace, and the value itself.
+func (ed *editor) parse(line string) (string, string, bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	if !strings.HasPrefix(trimmed, ed.key) {
+		return "", "", false
+	}
+
+	rest := trimmed[len(ed.key):]
+	if ed.separator == " " {
+		if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			return "", "", false
+		}
+	} else {
+		rest = strings.TrimLeft(rest, " \t")
+		if !strings.HasPrefix(rest, ed.separator) {
+			return "", "", false
+		}
+		rest = rest[len(ed.separator):]
+	}
+
+	value := strings.TrimLeft(rest, " \t")
+	prefix := line[:len(line)-len(value)]
+
+	return prefix, strings.TrimRight(value, " \t"), true
+}
+
+// uncomment returns line without the comment prefix if it's a
+// comment. If the key is separated by whitespace only the comment
+// prefix must be followed by the key immediately, otherwise prose
+// like "# Port forwarding ..." would be taken for a commented-out
+// assignment.
+func (ed *editor) uncomment(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" || !strings.ContainsRune(commentPrefixes, rune(trimmed[0])) {
+		return "", false
+	}
+
+	text := strings.TrimLeft(trimmed, commentPrefixes)
+	if ed.separator == " " && strings.TrimLeft(text, " \t") != text {
+		return "", false
+	}
+
+	return strings.TrimLeft(text, " \t"), true
+}
+
+// format returns the line that assigns ed.value to ed.key.
+func (ed *editor) format() string {
+	return ed.key + ed.separator + ed.value
+}
+
+// apply updates lines and returns the result as well as true if
+// anything changed.
+func (ed *editor) apply(lines []string) ([]string, bool) {
+	var (
+		current = ""
+		// start and end of the section (end is exclusive).
+		start, end  = -1, -1
+		found       = -1
+		commented   = -1
+		replacement string
+		changed     bool
+		result      = make([]string, 0, len(lines)+2)
+	)
+
+	if ed.section == "" {
+		start = 0
+	}
+
+	for _, line := range lines {
+		if name, ok := sectionName(line); ok {
+			if current == ed.section && start != -1 && end == -1 {
+				end = len(result)
+			}
+			current = name
+			if current == ed.section && start == -1 {
+				start = len(result) + 1
+			}
+			result = append(result, line)
+			continue
+		}
+
+		if current != ed.section {
+			result = append(result, line)
+			continue
+		}
+
+		if prefix, value, ok := ed.parse(line); ok {
+			switch {
+			case ed.absent || found != -1:
+				// remove the key or any duplicates.
+				changed = true
+			case value == ed.value:
+				found = len(result)
+				result = append(result, line)
+			default:
+				found = len(result)
+				// keep the indentation and the whitespace around
+				// the separator.
+				result = append(result, prefix+ed.value)
+				changed = true
+			}
+			continue
+		}
+
+		if text, ok := ed.uncomment(line); ok && commented == -1 {
+			if prefix, _, ok := ed.parse(text); ok {
+				commented = len(result)
+				replacement = prefix + ed.value
+			}
+		}
+
+		result = append(result, line)
+	}
+
+	if ed.absent || found != -1 {
+		return result, changed
+	}
+
+	if commented != -1 {
+		// uncomment the existing line instead of adding a
+		// duplicate.
+		result[commented] = replacement
+		return result, true
+	}
+
+	if start == -1 {
+		// the section does not exist yet.
+		if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
+			result = append(result, "")
+		}
+		return append(result, "["+ed.section+"]", ed.format()), true
+	}
+
+	if end == -1 {
+		end = len(result)
+	}
+
+	// insert after the last non-empty line of the section.
+	idx := end
+	for idx > start && strings.TrimSpace(result[idx-1]) == "" {
+		idx--
+	}
+
+	result = append(result, "")
+	copy(result[idx+1:], result[idx:])
+	result[idx] = ed.format()
+
+	return result, true
+}
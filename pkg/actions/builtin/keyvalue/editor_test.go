@@ -0,0 +1,73 @@
+package keyvalue
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditorApply(t *testing.T) {
+	cases := []struct {
+		name    string
+		editor  editor
+		input   string
+		output  string
+		changed bool
+	}{
+		{
+			name:    "replace value",
+			editor:  editor{key: "Port", value: "2222", separator: " "},
+			input:   "# comment\nPort  22\nPortForwarding no",
+			output:  "# comment\nPort  2222\nPortForwarding no",
+			changed: true,
+		},
+		{
+			name:   "unchanged",
+			editor: editor{key: "a", value: "1", separator: "="},
+			input:  "a = 1\nb=2",
+			output: "a = 1\nb=2",
+		},
+		{
+			name:    "uncomment",
+			editor:  editor{key: "Port", value: "2222", separator: " "},
+			input:   "# Port forwarding\n#Port 22\nFoo bar",
+			output:  "# Port forwarding\nPort 2222\nFoo bar",
+			changed: true,
+		},
+		{
+			name:    "remove duplicates",
+			editor:  editor{key: "a", value: "1", separator: "="},
+			input:   "a=0\n; a=3\na=2",
+			output:  "a=1\n; a=3",
+			changed: true,
+		},
+		{
+			name:    "append to section",
+			editor:  editor{key: "DNS", value: "1.1.1.1", section: "Resolve", separator: "="},
+			input:   "[Other]\nDNS=x\n\n[Resolve]\nFoo=bar\n\n[Last]",
+			output:  "[Other]\nDNS=x\n\n[Resolve]\nFoo=bar\nDNS=1.1.1.1\n\n[Last]",
+			changed: true,
+		},
+		{
+			name:    "append section",
+			editor:  editor{key: "a", value: "1", section: "new", separator: ":"},
+			input:   "[old]\na: 0",
+			output:  "[old]\na: 0\n\n[new]\na:1",
+			changed: true,
+		},
+		{
+			name:    "absent",
+			editor:  editor{key: "a", separator: "=", absent: true},
+			input:   "#a=1\na=2\n[s]\na=3",
+			output:  "#a=1\n[s]\na=3",
+			changed: true,
+		},
+	}
+
+	for _, c := range cases {
+		result, changed := c.editor.apply(strings.Split(c.input, "\n"))
+		assert.Equal(t, c.output, strings.Join(result, "\n"), c.name)
+		assert.Equal(t, c.changed, changed, c.name)
+	}
+}
@@ -0,0 +1,266 @@
+package keyvalue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	help := []actions.HelpSection{
+		{
+			Title: "File Format",
+			Description: "" +
+				"Each line of File= is either a comment (starting with `#` or `;`), a section header like `[section]` or an assignment " +
+				"like `key=value`. Comments, empty lines and the order of all lines are preserved. If Key= is already assigned " +
+				"the indentation and whitespace around the separator are kept and only the value is replaced. Any further assignments " +
+				"of Key= within the same section are removed. If Key= is not assigned but a commented-out assignment exists (like `#Port 22`) " +
+				"the first one is uncommented instead of adding a new line. Otherwise the assignment is appended to the end of the section " +
+				"or a new section is appended to the file.",
+		},
+		{
+			Title: "Change Detection",
+			Description: "" +
+				"File= is only replaced if its content changes. The task is only marked as changed if File= has been modified.",
+		},
+	}
+
+	options := []conf.OptionSpec{
+		{
+			Name:        "File",
+			Description: "The absolute path of the file to modify.",
+			Type:        conf.StringType,
+			Required:    true,
+		},
+		{
+			Name:        "Key",
+			Description: "The key to set or remove.",
+			Type:        conf.StringType,
+			Required:    true,
+		},
+		{
+			Name:        "Value",
+			Description: "The value of Key=. Required unless State=absent.",
+			Type:        conf.StringType,
+		},
+		{
+			Name:        "Section",
+			Description: "The name of the section (without brackets) that should contain Key=. If empty, Key= is expected before the first section header.",
+			Type:        conf.StringType,
+		},
+		{
+			Name:        "Separator",
+			Description: "The separator between key and value. Either `=`, `:` or `space` for any whitespace.",
+			Type:        conf.StringType,
+			Default:     "=",
+		},
+		{
+			Name:        "State",
+			Description: "Either `present` or `absent`. If `absent`, all assignments of Key= within Section= are removed. Comments are left untouched.",
+			Type:        conf.StringType,
+			Default:     statePresent,
+		},
+		{
+			Name:        "CreateFile",
+			Description: "If set to yes and File= does not exist it is created with mode 0644. Otherwise a missing file is an error.",
+			Type:        conf.BoolType,
+			Default:     "no",
+		},
+		{
+			Name:        "PreserveAttributes",
+			Description: "If set to yes, the owner, group and extended attributes (including POSIX ACLs) of File= are preserved when it is replaced.",
+			Type:        conf.BoolType,
+			Default:     "yes",
+		},
+	}
+
+	actions.MustRegister(actions.Plugin{
+		Name:        "KeyValue",
+		Description: "Set or remove keys in configuration files",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help:        help,
+		Options:     options,
+	})
+
+	actions.MustRegister(actions.Plugin{
+		Name:        "IniFile",
+		Description: "Set or remove keys in INI files (alias of KeyValue)",
+		Setup:       setupAction,
+		Example:     iniExample,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help:        help,
+		Options:     options,
+	})
+}
+
+// Supported values for the State= option.
+const (
+	statePresent = "present"
+	stateAbsent  = "absent"
+)
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	file, err := sec.GetString("File")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(file) {
+		return nil, fmt.Errorf("File must be absolute: %q", file)
+	}
+
+	key, err := sec.GetString("Key")
+	if err != nil {
+		return nil, err
+	}
+
+	if key == "" || strings.ContainsAny(key, " \t=:[]") {
+		return nil, fmt.Errorf("invalid value for Key: %q", key)
+	}
+
+	a := &action{
+		file: filepath.Clean(file),
+		editor: editor{
+			key:       key,
+			separator: "=",
+		},
+		createFile: sec.GetBoolDefault("CreateFile", false),
+		discard:    !sec.GetBoolDefault("PreserveAttributes", true),
+	}
+
+	state, err := sec.GetString("State")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		state = statePresent
+	}
+
+	if state != statePresent && state != stateAbsent {
+		return nil, fmt.Errorf("invalid value for State: %q", state)
+	}
+	a.editor.absent = state == stateAbsent
+
+	value, err := sec.GetString("Value")
+	switch {
+	case err == nil:
+		a.editor.value = value
+	case !conf.IsNotSet(err):
+		return nil, err
+	case !a.editor.absent:
+		return nil, fmt.Errorf("Value= is required")
+	}
+
+	if a.editor.section, err = sec.GetString("Section"); err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	separator, err := sec.GetString("Separator")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	switch separator {
+	case "", "=":
+	case ":":
+		a.editor.separator = ":"
+	case "space":
+		a.editor.separator = " "
+	default:
+		return nil, fmt.Errorf("invalid value for Separator: %q", separator)
+	}
+
+	return a, nil
+}
+
+type action struct {
+	actions.Base
+
+	file       string
+	editor     editor
+	createFile bool
+	discard    bool
+}
+
+func (a *action) Name() string {
+	return "KeyValue " + a.editor.key + " in " + a.file
+}
+
+func (a *action) Execute(_ context.Context) (bool, error) {
+	mode := os.FileMode(0644)
+
+	content, err := ioutil.ReadFile(a.file)
+	switch {
+	case err == nil:
+		if mode, err = utils.FileMode(a.file); err != nil {
+			return false, err
+		}
+	case !os.IsNotExist(err):
+		return false, err
+	case a.editor.absent:
+		return false, nil
+	case !a.createFile:
+		return false, fmt.Errorf("%s does not exist, set CreateFile=yes to create it", a.file)
+	}
+
+	lines := splitLines(content)
+	result, changed := a.editor.apply(lines)
+	if !changed {
+		return false, nil
+	}
+
+	data := []byte(strings.Join(result, "\n") + "\n")
+	opts := &utils.AtomicOptions{
+		DiscardOwner:  a.discard,
+		DiscardXattrs: a.discard,
+	}
+
+	if err := utils.CreateAtomicOwner(a.file, mode, -1, -1, bytes.NewReader(data), opts); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// splitLines splits content into lines. A trailing newline does not
+// start a new line.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+const example = `[Task]
+Description= Disable root login via SSH
+
+[KeyValue]
+File=/etc/ssh/sshd_config
+Key=PermitRootLogin
+Value=no
+Separator=space
+`
+
+const iniExample = `[Task]
+Description= Disable the systemd-resolved stub listener
+
+[IniFile]
+File=/etc/systemd/resolved.conf
+Section=Resolve
+Key=DNSStubListener
+Value=no
+`
@@ -0,0 +1,281 @@
+package patch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// devNull is used as the file name for created and deleted files.
+const devNull = "/dev/null"
+
+// fileDiff holds all hunks for a single file.
+type fileDiff struct {
+	oldName string
+	newName string
+	hunks   []*hunk
+}
+
+// hunk is a single hunk of a unified diff.
+type hunk struct {
+	header   string
+	oldStart int
+	newStart int
+	lines    []hunkLine
+}
+
+// hunkLine is a line of a hunk. op is either ' ', '-' or '+' and
+// text includes the trailing newline unless the line is the last
+// one of a file without a final newline.
+type hunkLine struct {
+	op   byte
+	text string
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch parses a unified diff. Everything outside of file
+// headers and hunks (like "diff --git" lines) is ignored.
+func parsePatch(data string) ([]*fileDiff, error) {
+	lines := splitLines(data)
+
+	var files []*fileDiff
+
+	for idx := 0; idx < len(lines); {
+		if !strings.HasPrefix(lines[idx], "--- ") || idx+1 >= len(lines) || !strings.HasPrefix(lines[idx+1], "+++ ") {
+			idx++
+			continue
+		}
+
+		fd := &fileDiff{
+			oldName: fileName(lines[idx]),
+			newName: fileName(lines[idx+1]),
+		}
+		idx += 2
+
+		for idx < len(lines) && strings.HasPrefix(lines[idx], "@@") {
+			h, next, err := parseHunk(lines, idx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fd.newName, err)
+			}
+
+			fd.hunks = append(fd.hunks, h)
+			idx = next
+		}
+
+		if len(fd.hunks) == 0 {
+			return nil, fmt.Errorf("%s: no hunks found", fd.newName)
+		}
+
+		files = append(files, fd)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no changes found")
+	}
+
+	return files, nil
+}
+
+// parseHunk parses the hunk starting at lines[idx] and returns it
+// together with the index of the first line after the hunk.
+func parseHunk(lines []string, idx int) (*hunk, int, error) {
+	header := strings.TrimRight(lines[idx], "\r\n")
+
+	m := hunkHeader.FindStringSubmatch(header)
+	if m == nil {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", header)
+	}
+
+	h := &hunk{header: header}
+	h.oldStart, _ = strconv.Atoi(m[1])
+	h.newStart, _ = strconv.Atoi(m[3])
+
+	oldLines, newLines := 1, 1
+	if m[2] != "" {
+		oldLines, _ = strconv.Atoi(m[2])
+	}
+	if m[4] != "" {
+		newLines, _ = strconv.Atoi(m[4])
+	}
+
+	for idx++; oldLines > 0 || newLines > 0; idx++ {
+		if idx >= len(lines) {
+			return nil, 0, fmt.Errorf("%s: unexpected end of patch", header)
+		}
+
+		line := lines[idx]
+		op := byte(' ')
+		text := line
+
+		switch {
+		case line == "\n" || line == "\r\n":
+			// some editors strip the trailing space of empty
+			// context lines.
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			op, text = line[0], line[1:]
+		case line[0] == '\\':
+			h.noNewline()
+			continue
+		default:
+			return nil, 0, fmt.Errorf("%s: invalid line %q", header, strings.TrimRight(line, "\r\n"))
+		}
+
+		if op != '+' {
+			oldLines--
+		}
+		if op != '-' {
+			newLines--
+		}
+
+		if oldLines < 0 || newLines < 0 {
+			return nil, 0, fmt.Errorf("%s: line counts do not match", header)
+		}
+
+		h.lines = append(h.lines, hunkLine{op: op, text: text})
+	}
+
+	if idx < len(lines) && strings.HasPrefix(lines[idx], "\\") {
+		h.noNewline()
+		idx++
+	}
+
+	return h, idx, nil
+}
+
+// noNewline handles a "\ No newline at end of file" marker by
+// removing the newline of the previous line.
+func (h *hunk) noNewline() {
+	if len(h.lines) > 0 {
+		last := &h.lines[len(h.lines)-1]
+		last.text = strings.TrimSuffix(last.text, "\n")
+	}
+}
+
+// creates returns true if the file is created by the patch.
+func (fd *fileDiff) creates() bool {
+	return fd.oldName == devNull || (len(fd.hunks) == 1 && fd.hunks[0].oldStart == 0)
+}
+
+// deletes returns true if the file is deleted by the patch.
+func (fd *fileDiff) deletes() bool {
+	return fd.newName == devNull || (len(fd.hunks) == 1 && fd.hunks[0].newStart == 0)
+}
+
+// fileName returns the file name of a "---" or "+++" line without
+// the optional timestamp.
+func fileName(line string) string {
+	name := strings.TrimRight(line[4:], "\r\n")
+	if idx := strings.IndexByte(name, '\t'); idx >= 0 {
+		name = name[:idx]
+	}
+	return strings.TrimSpace(name)
+}
+
+// split returns the lines that must be present before and after the
+// hunk has been applied. If reverse is set the hunk is reversed.
+func (h *hunk) split(reverse bool) (from, to []string, start int) {
+	for _, l := range h.lines {
+		if l.op != '+' {
+			from = append(from, l.text)
+		}
+		if l.op != '-' {
+			to = append(to, l.text)
+		}
+	}
+
+	start = h.oldStart
+	if reverse {
+		from, to = to, from
+		start = h.newStart
+	}
+
+	// for empty ranges the start line is the line before the
+	// hunk, otherwise it's the first line of the hunk.
+	if len(from) > 0 {
+		start--
+	}
+
+	return from, to, start
+}
+
+// apply applies hunks to content (which is split into lines using
+// splitLines). Hunks may be moved if the file has changed but their
+// content must match exactly. Reversed hunks that only add lines
+// (like deletions without context) match everywhere so the lines
+// they add must not already be present at the matching position.
+func apply(content []string, hunks []*hunk, reverse bool) ([]string, error) {
+	var (
+		result []string
+		pos    int
+		offset int
+	)
+
+	for n, h := range hunks {
+		from, to, start := h.split(reverse)
+
+		idx := find(content, from, pos, start+offset)
+		if idx < 0 {
+			return nil, fmt.Errorf("hunk #%d (%s) does not apply at line %d", n+1, h.header, start+offset+1)
+		}
+
+		if reverse && len(from) == 0 && len(to) > 0 &&
+			len(content)-idx >= len(to) && matches(content[idx:], to) {
+			return nil, fmt.Errorf("hunk #%d (%s) has not been applied at line %d", n+1, h.header, idx+1)
+		}
+
+		result = append(result, content[pos:idx]...)
+		result = append(result, to...)
+		pos = idx + len(from)
+		offset = idx - start
+	}
+
+	return append(result, content[pos:]...), nil
+}
+
+// find returns the index of lines within content that is closest
+// to expected but not before min. It returns -1 if lines is not
+// found.
+func find(content, lines []string, min, expected int) int {
+	last := len(content) - len(lines)
+	if last < min {
+		return -1
+	}
+
+	if expected < min {
+		expected = min
+	}
+	if expected > last {
+		expected = last
+	}
+
+	for delta := 0; expected-delta >= min || expected+delta <= last; delta++ {
+		if idx := expected - delta; idx >= min && matches(content[idx:], lines) {
+			return idx
+		}
+		if idx := expected + delta; idx <= last && matches(content[idx:], lines) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func matches(content, lines []string) bool {
+	for idx, line := range lines {
+		if content[idx] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits s into lines keeping the newline characters.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
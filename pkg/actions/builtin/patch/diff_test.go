@@ -0,0 +1,51 @@
+package patch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPatch = `diff --git a/file b/file
+--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ one
+-two
++TWO
+ three
+@@ -5,2 +5,3 @@
+ five
+ six
++seven
+\ No newline at end of file
+`
+
+func TestApply(t *testing.T) {
+	files, err := parsePatch(testPatch)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "a/file", files[0].oldName)
+
+	// the file has an additional line at the top so all hunks
+	// are moved by one line.
+	content := splitLines("zero\none\ntwo\nthree\nfour\nfive\nsix\n")
+
+	result, err := apply(content, files[0].hunks, false)
+	require.NoError(t, err)
+	assert.Equal(t, "zero\none\nTWO\nthree\nfour\nfive\nsix\nseven", strings.Join(result, ""))
+
+	// applying the patch again must fail but reversing it must
+	// work.
+	_, err = apply(result, files[0].hunks, false)
+	assert.Error(t, err)
+
+	reversed, err := apply(result, files[0].hunks, true)
+	require.NoError(t, err)
+	assert.Equal(t, content, reversed)
+
+	_, err = apply(splitLines("one\n2\nthree\n"), files[0].hunks, false)
+	assert.EqualError(t, err, "hunk #1 (@@ -1,3 +1,3 @@) does not apply at line 1")
+}
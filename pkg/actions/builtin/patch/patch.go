@@ -0,0 +1,275 @@
+package patch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "Patch",
+		Description: "Apply unified diffs to files",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Targets",
+				Description: "" +
+					"If Target= is a directory, the file names from the patch are used relative to Target= after removing Strip= leading " +
+					"path components, like `patch -p1` does. Patches may contain multiple files in this case. " +
+					"Otherwise the patch must contain exactly one file which is applied to Target=. " +
+					"Files created by the patch are created and files deleted by the patch are removed.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"Before applying the patch it is checked whether it can be reversed cleanly. In that case the patch has already been " +
+					"applied and the task is reported as pristine. Files deleted by the patch count as patched if they do not exist " +
+					"and lines removed by hunks without context must not be present anymore. Otherwise all hunks must apply (possibly at a different line) " +
+					"or the task fails with the first hunk that does not fit and no file is modified.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Patch",
+				Description: "The path to the unified diff. Relative paths are resolved relative to the task directory.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Target",
+				Description: "The absolute path of the file or directory to patch.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Strip",
+				Description: "The number of leading path components to strip from file names in the patch if Target= is a directory.",
+				Type:        conf.IntType,
+				Default:     "1",
+			},
+			{
+				Name:        "PreserveAttributes",
+				Description: "If set to yes, the owner, group and extended attributes (including POSIX ACLs) of patched files are preserved when they are replaced.",
+				Type:        conf.BoolType,
+				Default:     "yes",
+			},
+		},
+	})
+}
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	patch, err := sec.GetString("Patch")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(patch) {
+		patch = filepath.Join(task.Directory, patch)
+	}
+
+	target, err := sec.GetString("Target")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(target) {
+		return nil, fmt.Errorf("Target must be absolute: %q", target)
+	}
+
+	strip, err := sec.GetInt("Strip")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, fmt.Errorf("invalid value for Strip: %w", err)
+		}
+		strip = 1
+	}
+	if strip < 0 {
+		return nil, fmt.Errorf("invalid value for Strip: %d", strip)
+	}
+
+	return &action{
+		patch:   filepath.Clean(patch),
+		target:  filepath.Clean(target),
+		strip:   int(strip),
+		discard: !sec.GetBoolDefault("PreserveAttributes", true),
+	}, nil
+}
+
+type action struct {
+	actions.Base
+
+	patch   string
+	target  string
+	strip   int
+	discard bool
+}
+
+// patchedFile is a file with its content before and after
+// applying the patch.
+type patchedFile struct {
+	path    string
+	mode    os.FileMode
+	exists  bool
+	content []string
+	result  []string
+	// remove is set if the file is deleted by the patch.
+	remove bool
+}
+
+func (a *action) Name() string {
+	return "Patch " + a.target + " with " + a.patch
+}
+
+func (a *action) Execute(_ context.Context) (bool, error) {
+	data, err := ioutil.ReadFile(a.patch)
+	if err != nil {
+		return false, err
+	}
+
+	diffs, err := parsePatch(string(data))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", a.patch, err)
+	}
+
+	files := make([]*patchedFile, len(diffs))
+	for idx, fd := range diffs {
+		if files[idx], err = a.load(fd, len(diffs)); err != nil {
+			return false, err
+		}
+	}
+
+	// if the patch can be reversed it has already been applied.
+	reversed := true
+	for idx, fd := range diffs {
+		if fd.deletes() && !files[idx].exists {
+			continue
+		}
+
+		if _, err := apply(files[idx].content, fd.hunks, true); err != nil {
+			reversed = false
+			break
+		}
+	}
+
+	if reversed {
+		a.Debugf("%s has already been applied", a.patch)
+		return false, nil
+	}
+
+	for idx, fd := range diffs {
+		f := files[idx]
+
+		if f.result, err = apply(f.content, fd.hunks, false); err != nil {
+			return false, fmt.Errorf("%s: %s: %w", a.patch, f.path, err)
+		}
+
+		f.remove = fd.deletes() && len(f.result) == 0
+	}
+
+	opts := &utils.AtomicOptions{
+		DiscardOwner:  a.discard,
+		DiscardXattrs: a.discard,
+	}
+
+	for _, f := range files {
+		if err := f.write(opts); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// load reads the file patched by fd. count is the number of files
+// in the patch.
+func (a *action) load(fd *fileDiff, count int) (*patchedFile, error) {
+	path, err := a.resolve(fd, count)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &patchedFile{
+		path: path,
+		mode: 0644,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		f.exists = true
+		f.content = splitLines(string(data))
+		if f.mode, err = utils.FileMode(path); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	case !fd.creates() && !fd.deletes():
+		// deleted files are missing once the patch has been
+		// applied.
+		return nil, fmt.Errorf("%s does not exist", path)
+	}
+
+	return f, nil
+}
+
+// resolve returns the path of the file patched by fd.
+func (a *action) resolve(fd *fileDiff, count int) (string, error) {
+	stat, err := os.Stat(a.target)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err != nil || !stat.IsDir() {
+		if count != 1 {
+			return "", fmt.Errorf("%s contains %d files but Target= is not a directory", a.patch, count)
+		}
+		return a.target, nil
+	}
+
+	name := fd.newName
+	if name == devNull {
+		name = fd.oldName
+	}
+
+	parts := strings.FieldsFunc(filepath.ToSlash(name), func(r rune) bool { return r == '/' })
+	if len(parts) <= a.strip {
+		return "", fmt.Errorf("cannot strip %d components from %q", a.strip, name)
+	}
+
+	for _, p := range parts[a.strip:] {
+		if p == ".." {
+			return "", fmt.Errorf("%s: path traversal is not allowed", name)
+		}
+	}
+
+	return filepath.Join(a.target, filepath.Join(parts[a.strip:]...)), nil
+}
+
+func (f *patchedFile) write(opts *utils.AtomicOptions) error {
+	if f.remove {
+		return os.Remove(f.path)
+	}
+
+	content := strings.Join(f.result, "")
+	return utils.CreateAtomicOwner(f.path, f.mode, -1, -1, strings.NewReader(content), opts)
+}
+
+const example = `[Task]
+Description= Apply local fixes to the upstream configuration
+
+[Patch]
+Patch=files/nginx.conf.patch
+Target=/etc/nginx/nginx.conf
+`
@@ -0,0 +1,71 @@
+package patch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+// newTestAction returns an action that applies patch to a file with
+// the given content. If content is empty the file is not created.
+func newTestAction(t *testing.T, patch, content string) *action {
+	dir, err := ioutil.TempDir("", "patch")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	a := &action{
+		Base:   actions.Base{Logger: actions.NewLogger()},
+		patch:  filepath.Join(dir, "file.patch"),
+		target: filepath.Join(dir, "file"),
+	}
+
+	require.NoError(t, ioutil.WriteFile(a.patch, []byte(patch), 0644))
+	if content != "" {
+		require.NoError(t, ioutil.WriteFile(a.target, []byte(content), 0644))
+	}
+
+	return a
+}
+
+func TestPatchDelete(t *testing.T) {
+	a := newTestAction(t, "--- a/file\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-a\n-b\n", "a\nb\n")
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	_, err = os.Stat(a.target)
+	assert.True(t, os.IsNotExist(err))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestPatchWithoutContext(t *testing.T) {
+	// created with diff -U0
+	a := newTestAction(t, "--- a/file\n+++ b/file\n@@ -2 +1,0 @@\n-b\n", "a\nb\nc\nb\n")
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := ioutil.ReadFile(a.target)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nc\nb\n", string(data))
+
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	data, err = ioutil.ReadFile(a.target)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nc\nb\n", string(data))
+}
@@ -3,28 +3,63 @@ package platform
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/exec"
-	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/khulnasoft-lab/system-conf/conf"
 	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
 	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
 )
 
-var (
-	pacmanNothingToDoRegex = regexp.MustCompile("\n[ \t]{1}there is nothing to do\n")
-	aptChangedRegex        = regexp.MustCompile("[1-9]+[0-9]* (upgraded|newly|to remove)")
-)
-
 func init() {
 	actions.MustRegister(actions.Plugin{
 		Name:        "InstallPackages",
 		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
 		Website:     "https://github.com/khulnasoft-lab/system-deploy",
 		Description: "Install software packages using various package managers. For more control on the installation behavior use the Exec section instead.",
+		Help: []actions.HelpSection{
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"Before installing, `InstallPackages` queries the package database (`dpkg-query`, `pacman -Q` or `rpm -q`) to find out which packages are missing. " +
+					"Pacman package groups count as installed if all of their members are installed and APT virtual packages if an installed package provides them. " +
+					"If all packages are already installed the package manager is not invoked at all. " +
+					"The task is only marked as changed if at least one of the missing packages has been installed.",
+			},
+			{
+				Title: "Updates and Upgrades",
+				Description: "" +
+					"If UpdateCache= is set the package index is refreshed (`apt-get update`, `pacman -Sy` or `dnf makecache`) before any packages are installed. " +
+					"Use CacheValidSec= to skip the refresh if the package index is recent enough. " +
+					"Upgrade= may be used to upgrade all installed packages after the installation. For APT, `safe` runs `apt-get upgrade` while `full` " +
+					"runs `apt-get dist-upgrade`. For DNF, `safe` runs `dnf upgrade` while `full` runs `dnf distro-sync`. Pacman does not distinguish " +
+					"between both and always runs `pacman -Su`. Refreshing the package index does not mark the task as changed, upgrading packages " +
+					"only does if at least one package version changed.",
+			},
+			{
+				Title: "Package Mapping",
+				Description: "" +
+					"Packages listed in Packages= use logical, distribution neutral names that are resolved to package manager specific ones " +
+					"using a package mapping. Names without a mapping are passed through unchanged. The built-in mapping can be extended and overwritten " +
+					"using the file at PackageMap= (" + defaultPackageMapPath + "). Each line of the file starts with the logical package name followed " +
+					"by one or more `<manager>=<package>[,<package>...]` assignments, where manager is one of `apt`, `pacman` or `dnf`. Empty lines " +
+					"and lines starting with # are ignored. For example, `fd apt=fd-find pacman=fd dnf=fd-find`. " +
+					"The built-in mapping contains " + describeBuiltinPackageMap() + ".",
+			},
+		},
 		Options: []conf.OptionSpec{
+			{
+				Name:        "Packages",
+				Description: "Distribution neutral packages to install. Package names are resolved using the package mapping. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "PackageMap",
+				Description: "Path to the package mapping file that extends the built-in package mapping. It's fine if the file does not exist.",
+				Type:        conf.StringType,
+				Default:     defaultPackageMapPath,
+			},
 			{
 				Name:        "AptPkgs",
 				Description: "Packages to install if APT is available",
@@ -35,15 +70,31 @@ func init() {
 				Description: "Packages to install if Pacman is available",
 				Type:        conf.StringSliceType,
 			},
-			// TODO(khulnasoft-lab): add support for DNF
+			{
+				Name:        "DnfPkgs",
+				Description: "Packages to install if DNF is available",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "UpdateCache",
+				Description: "Refresh the package index before installing packages.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "CacheValidSec",
+				Description: "If set, the package index is only refreshed if it's older than the specified number of seconds. Requires UpdateCache=yes.",
+				Type:        conf.IntType,
+			},
+			{
+				Name:        "Upgrade",
+				Description: "Upgrade all installed packages. Supported values are `none`, `safe` and `full`.",
+				Type:        conf.StringType,
+				Default:     "none",
+			},
 			// TODO(khulnasoft-lab): add support for snap
 			// TODO(khulnasoft-lab): add support for arch-linux AUR (maybe using yay?)
 			/*
-				{
-					Name:        "DnfPkgs",
-					Description: "Packages to install if DNF is available",
-					Type:        deploy.StringSliceType,
-				},
 				{
 					Name:        "SnapPkgs",
 					Description: "Packages to install if Snap is available",
@@ -60,16 +111,69 @@ func setupInstallAction(task deploy.Task, sec conf.Section) (actions.Action, err
 	pacmanPkgs := getPackages("PacmanPkgs", sec)
 	dnfPkgs := getPackages("DnfPkgs", sec)
 	snapPkgs := getPackages("SnapPkgs", sec)
+	genericPkgs := getPackages("Packages", sec)
+
+	var pkgMap packageMap
+	if len(genericPkgs) > 0 {
+		mapPath, err := sec.GetString("PackageMap")
+		if err != nil {
+			if !conf.IsNotSet(err) {
+				return nil, err
+			}
+			mapPath = defaultPackageMapPath
+		}
+
+		pkgMap, err = loadPackageMap(mapPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load package mapping: %w", err)
+		}
+	}
+
+	updateCache, err := sec.GetBool("UpdateCache")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid setting for option 'UpdateCache': %w", err)
+	}
+
+	cacheValid, err := sec.GetInt("CacheValidSec")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid setting for option 'CacheValidSec': %w", err)
+	}
+	if cacheValid < 0 {
+		return nil, fmt.Errorf("invalid setting for option 'CacheValidSec': %d", cacheValid)
+	}
+	if err == nil && !updateCache {
+		return nil, fmt.Errorf("invalid setting for option 'CacheValidSec': requires UpdateCache=yes")
+	}
 
-	if len(aptPkgs) == 0 && len(pacmanPkgs) == 0 && len(dnfPkgs) == 0 && len(snapPkgs) == 0 {
+	upgrade, err := sec.GetString("Upgrade")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		upgrade = upgradeNone
+	}
+
+	switch upgrade {
+	case upgradeNone, upgradeSafe, upgradeFull:
+	default:
+		return nil, fmt.Errorf("invalid setting for option 'Upgrade': %q", upgrade)
+	}
+
+	if len(genericPkgs) == 0 && len(aptPkgs) == 0 && len(pacmanPkgs) == 0 && len(dnfPkgs) == 0 && len(snapPkgs) == 0 &&
+		!updateCache && upgrade == upgradeNone {
 		return nil, fmt.Errorf("no packages to install")
 	}
 
 	return &installAction{
-		aptPkgs:    aptPkgs,
-		pacmanPkgs: pacmanPkgs,
-		dnfPkgs:    dnfPkgs,
-		snapPkgs:   snapPkgs,
+		aptPkgs:     aptPkgs,
+		pacmanPkgs:  pacmanPkgs,
+		dnfPkgs:     dnfPkgs,
+		snapPkgs:    snapPkgs,
+		genericPkgs: genericPkgs,
+		pkgMap:      pkgMap,
+		updateCache: updateCache,
+		cacheValid:  time.Duration(cacheValid) * time.Second,
+		upgrade:     upgrade,
 	}, nil
 }
 
@@ -91,8 +195,22 @@ type installAction struct {
 	pacmanPkgs []string
 	dnfPkgs    []string
 	snapPkgs   []string
+
+	genericPkgs []string
+	pkgMap      packageMap
+
+	updateCache bool
+	cacheValid  time.Duration
+	upgrade     string
 }
 
+// Supported values for the Upgrade= option.
+const (
+	upgradeNone = "none"
+	upgradeSafe = "safe"
+	upgradeFull = "full"
+)
+
 func (ia *installAction) Name() string {
 	return "Installing packages"
 }
@@ -102,78 +220,217 @@ func (ia *installAction) Prepare(graph actions.ExecGraph) error {
 }
 
 func (ia *installAction) Execute(ctx context.Context) (bool, error) {
-	managers := getPackageManagers()
-
 	var changed bool
-	for _, m := range managers {
-		var err error
 
-		switch m {
-		case Pacman:
-			if len(ia.pacmanPkgs) == 0 {
-				continue
+	for _, m := range getPackageManagers() {
+		pm, ok := packageManagers[m]
+		if !ok {
+			continue
+		}
+
+		pkgs := ia.packagesFor(m)
+		if len(pkgs) == 0 && !ia.updateCache && ia.upgrade == upgradeNone {
+			continue
+		}
+
+		if ia.updateCache {
+			if err := ia.refreshCache(ctx, pm); err != nil {
+				return false, err
 			}
-			changed, err = installPacman(ctx, ia.pacmanPkgs...)
+		}
 
-		case APT:
-			if len(ia.aptPkgs) == 0 {
-				continue
+		if len(pkgs) > 0 {
+			installed, err := ia.installMissing(ctx, pm, pkgs)
+			if err != nil {
+				return false, err
 			}
-			changed, err = installApt(ctx, ia.aptPkgs...)
 
-		default:
-			continue
+			if len(installed) > 0 {
+				changed = true
+			}
 		}
 
-		if err != nil {
-			return false, err
+		if ia.upgrade != upgradeNone {
+			upgraded, err := ia.upgradeAll(ctx, pm)
+			if err != nil {
+				return false, err
+			}
+
+			if len(upgraded) > 0 {
+				changed = true
+			}
 		}
 	}
 
 	return changed, nil
 }
 
-func installPacman(ctx context.Context, pkgs ...string) (bool, error) {
+// refreshCache updates the package index of pm unless it's
+// younger than CacheValidSec=.
+func (ia *installAction) refreshCache(ctx context.Context, pm *packageManager) error {
+	if ia.cacheValid > 0 {
+		if age, ok := pm.cacheAge(); ok && age < ia.cacheValid {
+			ia.Debugf("%s package index is still valid (updated %s ago)", pm.name, age.Round(time.Second))
+			return nil
+		}
+	}
+
+	ia.Debugf("updating %s package index", pm.name)
+	if err := pm.updateCache(ctx); err != nil {
+		return fmt.Errorf("failed to update package index: %w", err)
+	}
+
+	return nil
+}
+
+// upgradeAll upgrades all installed packages and returns a list
+// of packages that have been upgraded, installed or removed.
+func (ia *installAction) upgradeAll(ctx context.Context, pm *packageManager) ([]string, error) {
+	before, err := pm.installed(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s package database: %w", pm.name, err)
+	}
+
+	ia.Debugf("upgrading packages using %s (%s)", pm.name, ia.upgrade)
+	if err := pm.upgrade(ctx, ia.upgrade == upgradeFull); err != nil {
+		return nil, fmt.Errorf("failed to upgrade packages: %w", err)
+	}
+
+	after, err := pm.installed(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s package database: %w", pm.name, err)
+	}
+
+	var upgraded []string
+	for name, version := range after {
+		if before[name] != version {
+			upgraded = append(upgraded, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			upgraded = append(upgraded, name)
+		}
+	}
+	sort.Strings(upgraded)
+
+	if len(upgraded) > 0 {
+		ia.Infof("upgraded packages using %s: %s", pm.name, strings.Join(upgraded, ", "))
+	}
+
+	return upgraded, nil
+}
+
+// packagesFor returns the packages configured for the package
+// manager m including all distribution neutral packages resolved
+// for m.
+func (ia *installAction) packagesFor(m string) []string {
+	var pkgs []string
+
+	switch m {
+	case APT:
+		pkgs = ia.aptPkgs
+	case Pacman:
+		pkgs = ia.pacmanPkgs
+	case Dnf:
+		pkgs = ia.dnfPkgs
+	case Snap:
+		return ia.snapPkgs
+	}
+
+	if len(ia.genericPkgs) > 0 {
+		pkgs = append(pkgs[:len(pkgs):len(pkgs)], ia.pkgMap.resolve(m, ia.genericPkgs)...)
+	}
+
+	return pkgs
+}
+
+// installMissing installs all packages from pkgs that are not yet
+// installed and returns a list of packages that have actually been
+// installed. If all packages are already installed the package manager
+// is not invoked at all.
+func (ia *installAction) installMissing(ctx context.Context, pm *packageManager, pkgs []string) ([]string, error) {
+	missing, err := pm.missing(ctx, pkgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(missing) == 0 {
+		ia.Debugf("all packages already installed using %s: %s", pm.name, strings.Join(pkgs, ", "))
+		return nil, nil
+	}
+
+	ia.Debugf("installing missing packages using %s: %s", pm.name, strings.Join(missing, ", "))
+	if err := pm.install(ctx, missing...); err != nil {
+		return nil, fmt.Errorf("failed to install packages: %w", err)
+	}
+
+	// query the package database again to find out what
+	// has actually been installed.
+	stillMissing, err := pm.missing(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stillMissing) > 0 {
+		// This may happen for package groups or virtual packages that
+		// are not reported by the package database.
+		ia.Warnf("packages not reported as installed by %s: %s", pm.name, strings.Join(stillMissing, ", "))
+	}
+
+	installed := subtract(missing, stillMissing)
+	if len(installed) > 0 {
+		ia.Infof("installed packages using %s: %s", pm.name, strings.Join(installed, ", "))
+	}
+
+	return installed, nil
+}
+
+func installPacman(ctx context.Context, pkgs ...string) error {
 	args := []string{
 		"-S",
 		"--needed",
 		"--quiet",
 		"--noconfirm",
 	}
-
 	args = append(args, pkgs...)
-	cmd := exec.CommandContext(ctx, "pacman", args...)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "LC_ALL=C")
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return false, fmt.Errorf("failed to install packages: %w\n%s", err, string(output))
+	return runPackageCommand(ctx, Pacman, args...)
+}
+
+func installApt(ctx context.Context, pkgs ...string) error {
+	args := []string{
+		"install",
+		"-y",
 	}
+	args = append(args, pkgs...)
 
-	nothingToDo := pacmanNothingToDoRegex.Match(output)
-	return !nothingToDo, nil
+	return runPackageCommand(ctx, "apt-get", args...)
 }
 
-func installApt(ctx context.Context, pkg ...string) (bool, error) {
+func installDnf(ctx context.Context, pkgs ...string) error {
 	args := []string{
 		"install",
 		"-y",
 	}
-	args = append(args, pkg...)
-
-	cmd := exec.CommandContext(ctx, "apt", args...)
-	cmd.Env = os.Environ()
+	args = append(args, pkgs...)
 
-	cmd.Env = append(cmd.Env, "DEBCONF_FRONTEND='noninteractive'")
-	cmd.Env = append(cmd.Env, "LC_ALL=C")
+	return runPackageCommand(ctx, Dnf, args...)
+}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return false, fmt.Errorf("failed to install packages: %w\n%s", err, string(output))
+// subtract returns all values from s that are not part of r.
+func subtract(s, r []string) []string {
+	lm := make(map[string]bool, len(r))
+	for _, v := range r {
+		lm[v] = true
 	}
 
-	hasChanged := aptChangedRegex.Match(output)
+	var result []string
+	for _, v := range s {
+		if !lm[v] {
+			result = append(result, v)
+		}
+	}
 
-	return hasChanged, nil
+	return result
 }
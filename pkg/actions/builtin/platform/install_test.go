@@ -0,0 +1,25 @@
+package platform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+)
+
+func TestSetupInstallCacheValid(t *testing.T) {
+	setup := func(options string) error {
+		tsk, err := deploy.Decode("test.task", strings.NewReader("[InstallPackages]\nPackages=curl\n"+options))
+		require.NoError(t, err)
+
+		_, err = setupInstallAction(*tsk, tsk.Sections[0])
+		return err
+	}
+
+	assert.NoError(t, setup("UpdateCache=yes\nCacheValidSec=3600\n"))
+	assert.Error(t, setup("CacheValidSec=3600\n"))
+	assert.Error(t, setup("UpdateCache=yes\nCacheValidSec=-1\n"))
+}
@@ -0,0 +1,392 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// packageManager describes how system-deploy interacts with
+// a supported package manager.
+type packageManager struct {
+	// name is the name of the package manager binary.
+	name string
+
+	// installed should query the package database and return
+	// the subset of pkgs that is currently installed along with
+	// their versions. If pkgs is empty, all installed packages
+	// should be returned.
+	installed func(ctx context.Context, pkgs ...string) (map[string]string, error)
+
+	// provided should return the subset of pkgs that are not
+	// reported by installed but are nevertheless satisfied, like
+	// package groups with all members installed or virtual
+	// packages provided by an installed package. It may be nil.
+	provided func(ctx context.Context, pkgs ...string) (map[string]bool, error)
+
+	// install should install all pkgs.
+	install func(ctx context.Context, pkgs ...string) error
+
+	// updateCache should refresh the package index.
+	updateCache func(ctx context.Context) error
+
+	// upgrade should upgrade all installed packages. If full is
+	// true, upgrades that require installing or removing other
+	// packages should be performed as well.
+	upgrade func(ctx context.Context, full bool) error
+
+	// cacheFiles is a list of glob patterns that match files
+	// touched when the package index is refreshed.
+	cacheFiles []string
+
+	// pkgName returns the name of the package as reported by
+	// installed. It's used to strip version or architecture
+	// qualifiers from user supplied package names.
+	pkgName func(pkg string) string
+}
+
+// packageManagers holds all package managers supported by
+// the InstallPackages action.
+var packageManagers = map[string]*packageManager{
+	APT: {
+		name:      APT,
+		installed: queryDpkg,
+		provided:  queryDpkgProvides,
+		install:   installApt,
+		updateCache: func(ctx context.Context) error {
+			return runPackageCommand(ctx, "apt-get", "update")
+		},
+		upgrade: func(ctx context.Context, full bool) error {
+			if full {
+				return runPackageCommand(ctx, "apt-get", "dist-upgrade", "-y")
+			}
+			return runPackageCommand(ctx, "apt-get", "upgrade", "-y")
+		},
+		cacheFiles: []string{
+			"/var/lib/apt/periodic/update-success-stamp",
+			"/var/lib/apt/lists",
+		},
+		pkgName: func(pkg string) string {
+			// strip version (foo=1.0), release (foo/buster) and
+			// architecture (foo:amd64) qualifiers.
+			if idx := strings.IndexAny(pkg, "=/:"); idx > 0 {
+				return pkg[:idx]
+			}
+			return pkg
+		},
+	},
+	Pacman: {
+		name:      Pacman,
+		installed: queryPacman,
+		provided:  queryPacmanGroups,
+		install:   installPacman,
+		updateCache: func(ctx context.Context) error {
+			return runPackageCommand(ctx, Pacman, "-Sy", "--noconfirm")
+		},
+		upgrade: func(ctx context.Context, _ bool) error {
+			// pacman does not distinguish between safe and
+			// full upgrades.
+			return runPackageCommand(ctx, Pacman, "-Su", "--noconfirm")
+		},
+		cacheFiles: []string{
+			"/var/lib/pacman/sync/*.db",
+		},
+		pkgName: func(pkg string) string {
+			// strip the repository (extra/foo)
+			if idx := strings.LastIndex(pkg, "/"); idx > -1 {
+				return pkg[idx+1:]
+			}
+			return pkg
+		},
+	},
+	Dnf: {
+		name:      Dnf,
+		installed: queryRpm,
+		install:   installDnf,
+		updateCache: func(ctx context.Context) error {
+			return runPackageCommand(ctx, Dnf, "makecache", "-y")
+		},
+		upgrade: func(ctx context.Context, full bool) error {
+			if full {
+				return runPackageCommand(ctx, Dnf, "distro-sync", "-y")
+			}
+			return runPackageCommand(ctx, Dnf, "upgrade", "-y")
+		},
+		cacheFiles: []string{
+			"/var/cache/dnf/*/repodata/repomd.xml",
+		},
+		pkgName: func(pkg string) string { return pkg },
+	},
+}
+
+// missing returns all packages from pkgs that are not installed.
+func (pm *packageManager) missing(ctx context.Context, pkgs ...string) ([]string, error) {
+	names := make([]string, len(pkgs))
+	for idx, p := range pkgs {
+		names[idx] = pm.pkgName(p)
+	}
+
+	installed, err := pm.installed(ctx, names...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s package database: %w", pm.name, err)
+	}
+
+	var unknown []string
+	for _, n := range names {
+		if _, ok := installed[n]; !ok {
+			unknown = append(unknown, n)
+		}
+	}
+
+	var provided map[string]bool
+	if len(unknown) > 0 && pm.provided != nil {
+		provided, err = pm.provided(ctx, unknown...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s package database: %w", pm.name, err)
+		}
+	}
+
+	var missing []string
+	for idx, p := range pkgs {
+		if _, ok := installed[names[idx]]; !ok && !provided[names[idx]] {
+			missing = append(missing, p)
+		}
+	}
+
+	return missing, nil
+}
+
+// cacheAge returns the time since the package index has been
+// refreshed the last time. If the age cannot be determined
+// ok is set to false.
+func (pm *packageManager) cacheAge() (age time.Duration, ok bool) {
+	var latest time.Time
+
+	for _, pattern := range pm.cacheFiles {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, m := range matches {
+			stat, err := os.Stat(m)
+			if err != nil {
+				continue
+			}
+
+			if stat.ModTime().After(latest) {
+				latest = stat.ModTime()
+			}
+		}
+	}
+
+	if latest.IsZero() {
+		return 0, false
+	}
+
+	return time.Since(latest), true
+}
+
+// packageCommand returns a new command for a package manager. It
+// ensures the command does not depend on the users locale and does
+// not require user interaction.
+func packageCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, "DEBIAN_FRONTEND=noninteractive")
+	cmd.Env = append(cmd.Env, "LC_ALL=C")
+
+	return cmd
+}
+
+// runPackageCommand executes a package manager command and returns
+// an error including the command output in case it fails.
+func runPackageCommand(ctx context.Context, name string, args ...string) error {
+	output, err := packageCommand(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", name, err, string(output))
+	}
+
+	return nil
+}
+
+// queryPackages executes a package database query and calls fn for
+// each line written to stdout. Query tools like dpkg-query, pacman
+// and rpm exit with a non-zero exit code if one of the packages
+// is not installed so only failures to execute the command are
+// reported.
+func queryPackages(ctx context.Context, fn func(line string), name string, args ...string) error {
+	var stdout, stderr bytes.Buffer
+
+	cmd := packageCommand(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+func queryDpkg(ctx context.Context, pkgs ...string) (map[string]string, error) {
+	installed := make(map[string]string)
+
+	args := append([]string{"-W", "-f=${Package} ${Version} ${Status}\\n"}, pkgs...)
+	err := queryPackages(ctx, func(line string) {
+		// ${Status} is reported as "<want> <flag> <status>"
+		// like "install ok installed" or "deinstall ok config-files"
+		fields := strings.Fields(line)
+		if len(fields) == 5 && fields[4] == "installed" {
+			installed[fields[0]] = fields[1]
+		}
+	}, "dpkg-query", args...)
+
+	return installed, err
+}
+
+// queryDpkgProvides returns all pkgs that are provided by an
+// installed package.
+func queryDpkgProvides(ctx context.Context, pkgs ...string) (map[string]bool, error) {
+	wanted := make(map[string]bool, len(pkgs))
+	for _, p := range pkgs {
+		wanted[p] = true
+	}
+
+	provided := make(map[string]bool)
+	err := queryPackages(ctx, func(line string) {
+		for _, p := range parseDpkgProvides(line) {
+			if wanted[p] {
+				provided[p] = true
+			}
+		}
+	}, "dpkg-query", "-W", "-f=${Status}|${Provides}\\n")
+
+	return provided, err
+}
+
+// parseDpkgProvides parses a "${Status}|${Provides}" line and returns
+// the names of all virtual packages provided by the package if it
+// is installed. Versions and architecture qualifiers are stripped.
+func parseDpkgProvides(line string) []string {
+	idx := strings.Index(line, "|")
+	if idx < 0 {
+		return nil
+	}
+
+	status := strings.Fields(line[:idx])
+	if len(status) != 3 || status[2] != "installed" {
+		return nil
+	}
+
+	var names []string
+	for _, p := range strings.Split(line[idx+1:], ",") {
+		fields := strings.Fields(p)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := fields[0]
+		if idx := strings.Index(name, ":"); idx > 0 {
+			name = name[:idx]
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func queryPacman(ctx context.Context, pkgs ...string) (map[string]string, error) {
+	installed := make(map[string]string)
+
+	args := append([]string{"-Q"}, pkgs...)
+	err := queryPackages(ctx, func(line string) {
+		// pacman -Q prints "<name> <version>" for each installed
+		// package.
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			installed[fields[0]] = fields[1]
+		}
+	}, Pacman, args...)
+
+	return installed, err
+}
+
+// queryPacmanGroups returns all pkgs that are package groups with
+// all members installed.
+func queryPacmanGroups(ctx context.Context, pkgs ...string) (map[string]bool, error) {
+	members := make(map[string][]string)
+
+	// pacman -Sg prints "<group> <package>" for each member of the
+	// requested groups.
+	args := append([]string{"-Sg"}, pkgs...)
+	err := queryPackages(ctx, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			members[fields[0]] = append(members[fields[0]], fields[1])
+		}
+	}, Pacman, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []string
+	for _, m := range members {
+		all = append(all, m...)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	installed, err := queryPacman(ctx, all...)
+	if err != nil {
+		return nil, err
+	}
+
+	provided := make(map[string]bool)
+	for group, m := range members {
+		provided[group] = true
+		for _, name := range m {
+			if _, ok := installed[name]; !ok {
+				provided[group] = false
+				break
+			}
+		}
+	}
+
+	return provided, nil
+}
+
+func queryRpm(ctx context.Context, pkgs ...string) (map[string]string, error) {
+	installed := make(map[string]string)
+
+	// rpm prints "package <name> is not installed" to stdout for
+	// missing packages so use a distinct prefix for installed ones.
+	args := []string{"-q", "--qf", "installed %{NAME} %{VERSION}-%{RELEASE}\\n"}
+	if len(pkgs) == 0 {
+		args = append(args, "-a")
+	}
+	args = append(args, pkgs...)
+
+	err := queryPackages(ctx, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "installed" {
+			installed[fields[1]] = fields[2]
+		}
+	}, "rpm", args...)
+
+	return installed, err
+}
@@ -0,0 +1,44 @@
+package platform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDpkgProvides(t *testing.T) {
+	assert.Equal(t,
+		[]string{"mail-transport-agent", "default-mta", "awk"},
+		parseDpkgProvides("install ok installed|mail-transport-agent, default-mta (= 4.94), awk:any"),
+	)
+	assert.Empty(t, parseDpkgProvides("install ok installed|"))
+	assert.Empty(t, parseDpkgProvides("deinstall ok config-files|default-mta"))
+	assert.Empty(t, parseDpkgProvides("install ok installed"))
+}
+
+func TestPackageManagerMissing(t *testing.T) {
+	pm := &packageManager{
+		name: "test",
+		installed: func(_ context.Context, pkgs ...string) (map[string]string, error) {
+			return map[string]string{"curl": "1.0"}, nil
+		},
+		provided: func(_ context.Context, pkgs ...string) (map[string]bool, error) {
+			assert.Equal(t, []string{"base-devel", "vim"}, pkgs)
+			return map[string]bool{"base-devel": true}, nil
+		},
+		pkgName: func(pkg string) string { return pkg },
+	}
+
+	missing, err := pm.missing(context.Background(), "curl", "base-devel", "vim")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vim"}, missing)
+
+	// package groups and virtual packages are not supported by
+	// every package manager.
+	pm.provided = nil
+	missing, err = pm.missing(context.Background(), "curl", "base-devel", "vim")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base-devel", "vim"}, missing)
+}
@@ -0,0 +1,153 @@
+package platform
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultPackageMapPath is the path of the user-defined package
+// mapping file.
+const defaultPackageMapPath = "/etc/system-deploy/package-map"
+
+// builtinPackageMap holds the built-in mapping of logical package
+// names to package manager specific ones. It uses the same format
+// as the user-defined mapping file.
+const builtinPackageMap = `
+build-essential apt=build-essential pacman=base-devel dnf=make,gcc,gcc-c++
+cron            apt=cron pacman=cronie dnf=cronie
+dnsutils        apt=dnsutils pacman=bind dnf=bind-utils
+docker          apt=docker.io pacman=docker dnf=moby-engine
+fd              apt=fd-find pacman=fd dnf=fd-find
+golang          apt=golang pacman=go dnf=golang
+openssh-client  apt=openssh-client pacman=openssh dnf=openssh-clients
+openssh-server  apt=openssh-server pacman=openssh dnf=openssh-server
+pip             apt=python3-pip pacman=python-pip dnf=python3-pip
+python3         apt=python3 pacman=python dnf=python3
+`
+
+// packageMap maps logical package names to a list of package
+// names per package manager.
+type packageMap map[string]map[string][]string
+
+// parsePackageMap parses a package mapping from r. Each line
+// starts with the logical package name followed by one or more
+// <manager>=<package>[,<package>...] assignments. Empty lines
+// and lines starting with # are ignored. Mappings from r are
+// added to m and replace existing mappings for the same logical
+// name and package manager.
+func parsePackageMap(m packageMap, name string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected at least one package manager mapping", name, lineNo)
+		}
+
+		entry, ok := m[fields[0]]
+		if !ok {
+			entry = make(map[string][]string)
+			m[fields[0]] = entry
+		}
+
+		for _, assignment := range fields[1:] {
+			parts := strings.SplitN(assignment, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("%s:%d: invalid mapping %q", name, lineNo, assignment)
+			}
+
+			manager := strings.ToLower(parts[0])
+			if _, ok := packageManagers[manager]; !ok {
+				return fmt.Errorf("%s:%d: unsupported package manager %q", name, lineNo, parts[0])
+			}
+
+			entry[manager] = strings.Split(parts[1], ",")
+		}
+	}
+
+	return scanner.Err()
+}
+
+// loadPackageMap returns the built-in package mapping extended by
+// the mapping file at path. A missing file is not treated as an
+// error.
+func loadPackageMap(path string) (packageMap, error) {
+	m := make(packageMap)
+
+	if err := parsePackageMap(m, "built-in", strings.NewReader(builtinPackageMap)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := parsePackageMap(m, path, f); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// resolve returns the package names for manager. Packages without a
+// mapping for manager are returned unchanged.
+func (m packageMap) resolve(manager string, pkgs []string) []string {
+	var result []string
+
+	for _, p := range pkgs {
+		if names, ok := m[p][manager]; ok {
+			result = append(result, names...)
+			continue
+		}
+
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// describeBuiltinPackageMap returns a human readable description of
+// the built-in package mapping.
+func describeBuiltinPackageMap() string {
+	m := make(packageMap)
+	if err := parsePackageMap(m, "built-in", strings.NewReader(builtinPackageMap)); err != nil {
+		panic(err)
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]string, len(names))
+	for idx, name := range names {
+		var mappings []string
+		for _, manager := range []string{APT, Pacman, Dnf} {
+			if pkgs, ok := m[name][manager]; ok {
+				mappings = append(mappings, manager+": "+strings.Join(pkgs, " "))
+			}
+		}
+
+		entries[idx] = fmt.Sprintf("`%s` (%s)", name, strings.Join(mappings, ", "))
+	}
+
+	return strings.Join(entries, ", ")
+}
@@ -0,0 +1,29 @@
+package platform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageMap(t *testing.T) {
+	m := make(packageMap)
+
+	require.NoError(t, parsePackageMap(m, "built-in", strings.NewReader(builtinPackageMap)))
+	require.NoError(t, parsePackageMap(m, "test", strings.NewReader(`
+# override the built-in mapping for apt
+fd      apt=fdfind
+neovim  pacman=neovim,python-pynvim
+	`)))
+
+	assert.Equal(t, []string{"fdfind", "curl"}, m.resolve(APT, []string{"fd", "curl"}))
+	assert.Equal(t, []string{"fd", "curl"}, m.resolve(Pacman, []string{"fd", "curl"}))
+	assert.Equal(t, []string{"neovim", "python-pynvim"}, m.resolve(Pacman, []string{"neovim"}))
+	assert.Equal(t, []string{"neovim"}, m.resolve(APT, []string{"neovim"}))
+
+	assert.Error(t, parsePackageMap(m, "test", strings.NewReader("fd")))
+	assert.Error(t, parsePackageMap(m, "test", strings.NewReader("fd brew=fd")))
+	assert.Error(t, parsePackageMap(m, "test", strings.NewReader("fd apt=")))
+}
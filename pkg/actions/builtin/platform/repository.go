@@ -0,0 +1,571 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "PackageRepository",
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Description: "Manage additional package repositories for APT and Pacman.",
+		Example:     repositoryExample,
+		Help: []actions.HelpSection{
+			{
+				Title: "APT",
+				Description: "" +
+					"For APT the repository is written to `<AptSourcesDirectory>/<Name>.list` (or `<Name>.sources` if AptFormat=deb822). " +
+					"If a signing key is configured using AptKeyFile= or AptKeyURL= it is stored in AptKeyringDirectory= and referenced using `signed-by`. " +
+					"ASCII armored keys are stored with a `.asc` extension, binary keys with a `.gpg` extension. " +
+					"A key or source file left over from the other format is removed.",
+			},
+			{
+				Title: "Pacman",
+				Description: "" +
+					"For Pacman the repository is managed as a `[<Name>]` section in PacmanConfig=. " +
+					"An existing section with the same name is replaced while all other sections and comments are kept as they are. " +
+					"If the section exists multiple times only the first one is replaced. " +
+					"New sections are appended to the end of the file.",
+			},
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"Files are only written if their content differs from the expected one. " +
+					"If any file has been updated and UpdateCacheOnChange= is set, the package index of the respective package manager is refreshed " +
+					"so following InstallPackages tasks see the new repository.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Name",
+				Description: "The name of the repository. It is used as the file name for APT and as the section name for Pacman.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "UpdateCacheOnChange",
+				Description: "Whether or not the package index should be refreshed if the repository has been changed.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "AptURIs",
+				Description: "The base URIs of the APT repository. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "AptSuites",
+				Description: "The suites (distribution codenames) of the APT repository. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "AptComponents",
+				Description: "The components of the APT repository. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "AptArchitectures",
+				Description: "Restrict the APT repository to the given architectures. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "AptTypes",
+				Description: "The archive types of the APT repository. Either `deb`, `deb-src` or both.",
+				Type:        conf.StringSliceType,
+				Default:     "deb",
+			},
+			{
+				Name:        "AptFormat",
+				Description: "The format of the APT source file. Either `list` for the one-line-style format or `deb822`.",
+				Type:        conf.StringType,
+				Default:     "list",
+			},
+			{
+				Name:        "AptKeyFile",
+				Description: "Path to the signing key of the APT repository. Relative paths are resolved against the task directory.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "AptKeyURL",
+				Description: "URL to download the signing key of the APT repository from.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "AptSourcesDirectory",
+				Description: "The directory for APT source files.",
+				Type:        conf.StringType,
+				Default:     "/etc/apt/sources.list.d",
+			},
+			{
+				Name:        "AptKeyringDirectory",
+				Description: "The directory for APT repository signing keys.",
+				Type:        conf.StringType,
+				Default:     "/etc/apt/keyrings",
+			},
+			{
+				Name:        "PacmanServer",
+				Description: "The server URL of the Pacman repository. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "PacmanSigLevel",
+				Description: "The SigLevel for the Pacman repository.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "PacmanConfig",
+				Description: "Path to the Pacman configuration file.",
+				Type:        conf.StringType,
+				Default:     "/etc/pacman.conf",
+			},
+		},
+		Setup: setupRepositoryAction,
+	})
+}
+
+// managedHeader is placed at the top of each file that is fully
+// managed by system-deploy.
+const managedHeader = "# Managed by system-deploy. Do not edit.\n"
+
+func setupRepositoryAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	name, err := sec.GetString("Name")
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" || strings.ContainsAny(name, "/[] \t") {
+		return nil, fmt.Errorf("invalid repository name %q", name)
+	}
+
+	ra := &repositoryAction{
+		name:        name,
+		updateCache: sec.GetBoolDefault("UpdateCacheOnChange", false),
+	}
+
+	if uris := sec.GetStringSlice("AptURIs"); len(uris) > 0 {
+		apt := &aptRepository{
+			uris:          uris,
+			suites:        getPackages("AptSuites", sec),
+			components:    getPackages("AptComponents", sec),
+			architectures: getPackages("AptArchitectures", sec),
+			types:         getPackages("AptTypes", sec),
+		}
+
+		if len(apt.suites) == 0 {
+			return nil, fmt.Errorf("AptSuites= is required")
+		}
+
+		if len(apt.types) == 0 {
+			apt.types = []string{"deb"}
+		}
+
+		apt.format, err = getStringDefault(sec, "AptFormat", "list")
+		if err != nil {
+			return nil, err
+		}
+		if apt.format != "list" && apt.format != "deb822" {
+			return nil, fmt.Errorf("invalid value for AptFormat: %q", apt.format)
+		}
+
+		apt.sourcesDir, err = getStringDefault(sec, "AptSourcesDirectory", "/etc/apt/sources.list.d")
+		if err != nil {
+			return nil, err
+		}
+
+		apt.keyringDir, err = getStringDefault(sec, "AptKeyringDirectory", "/etc/apt/keyrings")
+		if err != nil {
+			return nil, err
+		}
+
+		apt.keyFile, err = getStringDefault(sec, "AptKeyFile", "")
+		if err != nil {
+			return nil, err
+		}
+		if apt.keyFile != "" && !filepath.IsAbs(apt.keyFile) {
+			apt.keyFile = filepath.Clean(filepath.Join(task.Directory, apt.keyFile))
+		}
+
+		apt.keyURL, err = getStringDefault(sec, "AptKeyURL", "")
+		if err != nil {
+			return nil, err
+		}
+
+		if apt.keyFile != "" && apt.keyURL != "" {
+			return nil, fmt.Errorf("cannot use AptKeyFile= and AptKeyURL= at the same time")
+		}
+
+		ra.apt = apt
+	}
+
+	if servers := sec.GetStringSlice("PacmanServer"); len(servers) > 0 {
+		pacman := &pacmanRepository{
+			servers: servers,
+		}
+
+		pacman.sigLevel, err = getStringDefault(sec, "PacmanSigLevel", "")
+		if err != nil {
+			return nil, err
+		}
+
+		pacman.config, err = getStringDefault(sec, "PacmanConfig", "/etc/pacman.conf")
+		if err != nil {
+			return nil, err
+		}
+
+		ra.pacman = pacman
+	}
+
+	if ra.apt == nil && ra.pacman == nil {
+		return nil, fmt.Errorf("either AptURIs= or PacmanServer= must be set")
+	}
+
+	return ra, nil
+}
+
+// getStringDefault returns the value of the option name or def if
+// the option is not set.
+func getStringDefault(sec conf.Section, name string, def string) (string, error) {
+	val, err := sec.GetString(name)
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return "", fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		return def, nil
+	}
+
+	return val, nil
+}
+
+type aptRepository struct {
+	uris          []string
+	suites        []string
+	components    []string
+	architectures []string
+	types         []string
+	format        string
+	keyFile       string
+	keyURL        string
+	sourcesDir    string
+	keyringDir    string
+}
+
+type pacmanRepository struct {
+	servers  []string
+	sigLevel string
+	config   string
+}
+
+type repositoryAction struct {
+	actions.Base
+
+	name        string
+	updateCache bool
+	apt         *aptRepository
+	pacman      *pacmanRepository
+}
+
+func (ra *repositoryAction) Name() string {
+	return "Package repository " + ra.name
+}
+
+func (ra *repositoryAction) Execute(ctx context.Context) (bool, error) {
+	var changed bool
+
+	for _, m := range getPackageManagers() {
+		var (
+			updated bool
+			err     error
+		)
+
+		switch m {
+		case APT:
+			if ra.apt == nil {
+				continue
+			}
+			updated, err = ra.deployApt(ctx)
+
+		case Pacman:
+			if ra.pacman == nil {
+				continue
+			}
+			updated, err = ra.deployPacman()
+
+		default:
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if !updated {
+			continue
+		}
+		changed = true
+
+		if ra.updateCache {
+			ra.Infof("updating %s package index", m)
+			if err := packageManagers[m].updateCache(ctx); err != nil {
+				return changed, fmt.Errorf("failed to update package index: %w", err)
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+// deployApt installs the signing key and the source file for the
+// APT repository.
+func (ra *repositoryAction) deployApt(ctx context.Context) (bool, error) {
+	var (
+		changed bool
+		keyPath string
+	)
+
+	if ra.apt.keyFile != "" || ra.apt.keyURL != "" {
+		key, err := ra.apt.loadKey(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to load signing key: %w", err)
+		}
+
+		ext, other := ".gpg", ".asc"
+		if bytes.HasPrefix(bytes.TrimSpace(key), []byte("-----BEGIN PGP")) {
+			ext, other = other, ext
+		}
+		keyPath = filepath.Join(ra.apt.keyringDir, ra.name+ext)
+
+		if err := os.MkdirAll(ra.apt.keyringDir, 0755); err != nil {
+			return false, err
+		}
+
+		updated, err := utils.UpdateAtomic(keyPath, 0644, key)
+		if err != nil {
+			return false, fmt.Errorf("failed to write signing key: %w", err)
+		}
+		changed = changed || updated
+
+		// the key may have been stored using the other format
+		// before.
+		removed, err := removeIfExists(filepath.Join(ra.apt.keyringDir, ra.name+other))
+		if err != nil {
+			return false, fmt.Errorf("failed to remove old signing key: %w", err)
+		}
+		changed = changed || removed
+	}
+
+	var (
+		content    []byte
+		ext, other string
+	)
+	if ra.apt.format == "deb822" {
+		content = ra.apt.deb822(keyPath)
+		ext, other = ".sources", ".list"
+	} else {
+		content = ra.apt.oneLineStyle(keyPath)
+		ext, other = ".list", ".sources"
+	}
+
+	if err := os.MkdirAll(ra.apt.sourcesDir, 0755); err != nil {
+		return false, err
+	}
+
+	updated, err := utils.UpdateAtomic(filepath.Join(ra.apt.sourcesDir, ra.name+ext), 0644, content)
+	if err != nil {
+		return false, fmt.Errorf("failed to write source file: %w", err)
+	}
+
+	// APT complains about sources configured twice so remove the
+	// file of the other format.
+	removed, err := removeIfExists(filepath.Join(ra.apt.sourcesDir, ra.name+other))
+	if err != nil {
+		return false, fmt.Errorf("failed to remove old source file: %w", err)
+	}
+
+	return changed || updated || removed, nil
+}
+
+// removeIfExists removes path and returns true if it existed.
+func removeIfExists(path string) (bool, error) {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// loadKey loads the signing key either from a local file or by
+// downloading it.
+func (apt *aptRepository) loadKey(ctx context.Context) ([]byte, error) {
+	if apt.keyFile != "" {
+		return ioutil.ReadFile(apt.keyFile)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, apt.keyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %s", res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// oneLineStyle returns the content for a sources.list file.
+func (apt *aptRepository) oneLineStyle(keyPath string) []byte {
+	var opts []string
+	if len(apt.architectures) > 0 {
+		opts = append(opts, "arch="+strings.Join(apt.architectures, ","))
+	}
+	if keyPath != "" {
+		opts = append(opts, "signed-by="+keyPath)
+	}
+
+	optStr := ""
+	if len(opts) > 0 {
+		optStr = " [" + strings.Join(opts, " ") + "]"
+	}
+
+	buf := bytes.NewBufferString(managedHeader)
+	for _, t := range apt.types {
+		for _, uri := range apt.uris {
+			for _, suite := range apt.suites {
+				line := t + optStr + " " + uri + " " + suite
+				if len(apt.components) > 0 {
+					line += " " + strings.Join(apt.components, " ")
+				}
+				buf.WriteString(line + "\n")
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// deb822 returns the content for a deb822 style .sources file.
+func (apt *aptRepository) deb822(keyPath string) []byte {
+	buf := bytes.NewBufferString(managedHeader)
+
+	fmt.Fprintf(buf, "Types: %s\n", strings.Join(apt.types, " "))
+	fmt.Fprintf(buf, "URIs: %s\n", strings.Join(apt.uris, " "))
+	fmt.Fprintf(buf, "Suites: %s\n", strings.Join(apt.suites, " "))
+	if len(apt.components) > 0 {
+		fmt.Fprintf(buf, "Components: %s\n", strings.Join(apt.components, " "))
+	}
+	if len(apt.architectures) > 0 {
+		fmt.Fprintf(buf, "Architectures: %s\n", strings.Join(apt.architectures, " "))
+	}
+	if keyPath != "" {
+		fmt.Fprintf(buf, "Signed-By: %s\n", keyPath)
+	}
+
+	return buf.Bytes()
+}
+
+// deployPacman updates the repository section in the pacman
+// configuration file.
+func (ra *repositoryAction) deployPacman() (bool, error) {
+	content, err := ioutil.ReadFile(ra.pacman.config)
+	if err != nil {
+		return false, err
+	}
+
+	mode, err := utils.FileMode(ra.pacman.config)
+	if err != nil {
+		return false, err
+	}
+
+	var body []string
+	if ra.pacman.sigLevel != "" {
+		body = append(body, "SigLevel = "+ra.pacman.sigLevel)
+	}
+	for _, server := range ra.pacman.servers {
+		body = append(body, "Server = "+server)
+	}
+
+	return utils.UpdateAtomic(ra.pacman.config, mode, setPacmanSection(content, ra.name, body))
+}
+
+// setPacmanSection replaces the body of the section name in the
+// pacman configuration content. If the section does not exist it
+// is appended. If the section is specified multiple times, only the
+// first one is replaced.
+func setPacmanSection(content []byte, name string, body []string) []byte {
+	var (
+		result    []string
+		found     bool
+		inSection bool
+	)
+
+	section := append([]string{"[" + name + "]"}, body...)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			inSection = !found && trimmed == "["+name+"]"
+			if inSection {
+				found = true
+				result = append(result, section...)
+				continue
+			}
+		}
+
+		// drop all options of the existing section but keep
+		// comments and blank lines as they likely belong to
+		// the following section.
+		if inSection && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		result = append(result, line)
+	}
+
+	if !found {
+		if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
+			result = append(result, "")
+		}
+		result = append(result, section...)
+	}
+
+	return []byte(strings.Join(result, "\n") + "\n")
+}
+
+const repositoryExample = `[Task]
+Description= Add the docker repository
+
+[PackageRepository]
+Name=docker
+AptURIs=https://download.docker.com/linux/ubuntu
+AptSuites=focal
+AptComponents=stable
+AptArchitectures=amd64
+AptKeyURL=https://download.docker.com/linux/ubuntu/gpg
+UpdateCacheOnChange=yes
+`
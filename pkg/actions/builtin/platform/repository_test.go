@@ -0,0 +1,98 @@
+package platform
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+func TestSetPacmanSection(t *testing.T) {
+	cases := []struct {
+		I string
+		O string
+	}{
+		{
+			"[options]\nHoldPkg = pacman\n",
+			"[options]\nHoldPkg = pacman\n\n[custom]\nSigLevel = Optional\nServer = https://example.com\n",
+		},
+		{
+			"[options]\n\n[custom]\nServer = https://old.example.com\n\n#[testing]\n#Include = /etc/pacman.d/mirrorlist\n",
+			"[options]\n\n[custom]\nSigLevel = Optional\nServer = https://example.com\n\n#[testing]\n#Include = /etc/pacman.d/mirrorlist\n",
+		},
+		{
+			"[custom]\nServer = https://old.example.com\n[core]\nInclude = /etc/pacman.d/mirrorlist\n",
+			"[custom]\nSigLevel = Optional\nServer = https://example.com\n[core]\nInclude = /etc/pacman.d/mirrorlist\n",
+		},
+		{
+			// only the first of duplicated sections is updated.
+			"[custom]\nServer = https://old.example.com\n[custom]\nServer = https://other.example.com\n",
+			"[custom]\nSigLevel = Optional\nServer = https://example.com\n[custom]\nServer = https://other.example.com\n",
+		},
+	}
+
+	body := []string{"SigLevel = Optional", "Server = https://example.com"}
+
+	for idx, c := range cases {
+		result := setPacmanSection([]byte(c.I), "custom", body)
+		assert.Equal(t, c.O, string(result), "case #%d", idx)
+
+		// applying the section again must not change anything
+		assert.Equal(t, c.O, string(setPacmanSection(result, "custom", body)), "case #%d", idx)
+	}
+}
+
+func TestDeployApt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repository")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n"), 0644))
+
+	ra := &repositoryAction{
+		Base: actions.Base{Logger: actions.NewLogger()},
+		name: "custom",
+		apt: &aptRepository{
+			uris:       []string{"https://example.com"},
+			suites:     []string{"stable"},
+			types:      []string{"deb"},
+			format:     "list",
+			keyFile:    keyFile,
+			sourcesDir: filepath.Join(dir, "sources.list.d"),
+			keyringDir: filepath.Join(dir, "keyrings"),
+		},
+	}
+
+	// missing directories are created.
+	changed, err := ra.deployApt(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.FileExists(t, filepath.Join(dir, "keyrings/custom.asc"))
+	assert.FileExists(t, filepath.Join(dir, "sources.list.d/custom.list"))
+
+	changed, err = ra.deployApt(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// switching the key and source format removes the old files.
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte{0x99, 0x01}, 0644))
+	ra.apt.format = "deb822"
+
+	changed, err = ra.deployApt(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*/custom.*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "keyrings/custom.gpg"),
+		filepath.Join(dir, "sources.list.d/custom.sources"),
+	}, files)
+}
@@ -0,0 +1,231 @@
+package symlink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "Symlink",
+		Description: "Manage symbolic links",
+		Setup:       setupAction,
+		Example:     example,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"The task is only marked as changed if Link= has been created, replaced or removed. " +
+					"A link that already points to Target= is left untouched. " +
+					"Links are replaced atomically so Link= never disappears while it is updated.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Target",
+				Description: "The path the link should point to. Relative paths are resolved relative to the directory of Link= and stored as they are. Required unless State=absent.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Link",
+				Description: "The absolute path of the symbolic link.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "Force",
+				Description: "If set to yes, an existing file or a symbolic link pointing somewhere else is replaced. Directories are never replaced.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "CreateDirectories",
+				Description: "If set to yes, missing parent directories of Link= are created.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "State",
+				Description: "Either `present` or `absent`. If `absent`, Link= is removed if it is a symbolic link.",
+				Type:        conf.StringType,
+				Default:     statePresent,
+			},
+		},
+	})
+}
+
+// Supported values for the State= option.
+const (
+	statePresent = "present"
+	stateAbsent  = "absent"
+)
+
+func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	link, err := sec.GetString("Link")
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(link) {
+		return nil, fmt.Errorf("Link must be absolute: %q", link)
+	}
+
+	a := &action{
+		link: filepath.Clean(link),
+	}
+
+	a.state, err = sec.GetString("State")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, err
+		}
+		a.state = statePresent
+	}
+
+	if a.state != statePresent && a.state != stateAbsent {
+		return nil, fmt.Errorf("invalid value for State: %q", a.state)
+	}
+
+	a.target, err = sec.GetString("Target")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, err
+	}
+
+	if a.state == statePresent && a.target == "" {
+		return nil, fmt.Errorf("Target= is required")
+	}
+
+	a.force, err = sec.GetBool("Force")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for Force: %w", err)
+	}
+
+	a.createDirectories, err = sec.GetBool("CreateDirectories")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for CreateDirectories: %w", err)
+	}
+
+	return a, nil
+}
+
+type action struct {
+	actions.Base
+
+	target            string
+	link              string
+	state             string
+	force             bool
+	createDirectories bool
+}
+
+func (a *action) Name() string {
+	return "Symlink " + a.link
+}
+
+func (a *action) Execute(_ context.Context) (bool, error) {
+	stat, err := os.Lstat(a.link)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	exists := err == nil
+	isLink := exists && stat.Mode()&os.ModeSymlink != 0
+
+	if a.state == stateAbsent {
+		if !exists {
+			return false, nil
+		}
+
+		if !isLink {
+			return false, fmt.Errorf("%s exists but is not a symbolic link", a.link)
+		}
+
+		return true, os.Remove(a.link)
+	}
+
+	if isLink {
+		current, err := os.Readlink(a.link)
+		if err != nil {
+			return false, err
+		}
+
+		if current == a.target {
+			return false, nil
+		}
+
+		if !a.force {
+			return false, fmt.Errorf("%s already points to %s, set Force=yes to replace it", a.link, current)
+		}
+	} else if exists {
+		if stat.IsDir() {
+			return false, fmt.Errorf("%s exists and is a directory", a.link)
+		}
+
+		if !a.force {
+			return false, fmt.Errorf("%s already exists, set Force=yes to replace it", a.link)
+		}
+	}
+
+	if a.createDirectories {
+		if err := os.MkdirAll(filepath.Dir(a.link), 0755); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := os.Stat(a.resolvedTarget()); os.IsNotExist(err) {
+		a.Warnf("target %s of %s does not exist", a.target, a.link)
+	}
+
+	if err := a.replace(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// resolvedTarget returns the target of the link relative to the
+// current working directory.
+func (a *action) resolvedTarget() string {
+	if filepath.IsAbs(a.target) {
+		return a.target
+	}
+	return filepath.Join(filepath.Dir(a.link), a.target)
+}
+
+// replace creates the symbolic link next to a.link and renames it
+// to a.link to atomically replace whatever exists there.
+func (a *action) replace() error {
+	tmp := filepath.Join(
+		filepath.Dir(a.link),
+		"."+filepath.Base(a.link)+"."+strconv.FormatInt(time.Now().UnixNano(), 36),
+	)
+
+	if err := os.Symlink(a.target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, a.link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+const example = `[Task]
+Description= Enable the default nginx site
+
+[Symlink]
+Target=../sites-available/default
+Link=/etc/nginx/sites-enabled/default
+Force=yes
+`
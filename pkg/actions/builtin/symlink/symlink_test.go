@@ -0,0 +1,130 @@
+package symlink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+)
+
+func newAction(t *testing.T, target string) (*action, string) {
+	dir, err := ioutil.TempDir("", "symlink")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return &action{
+		Base:   actions.Base{Logger: actions.NewLogger()},
+		target: target,
+		link:   filepath.Join(dir, "link"),
+		state:  statePresent,
+	}, dir
+}
+
+func readlink(t *testing.T, path string) string {
+	target, err := os.Readlink(path)
+	require.NoError(t, err)
+	return target
+}
+
+func TestSymlink(t *testing.T) {
+	a, _ := newAction(t, "target")
+
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "target", readlink(t, a.link))
+
+	// a link that already points to Target= is pristine.
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestSymlinkForce(t *testing.T) {
+	a, _ := newAction(t, "target")
+
+	// an existing link is only replaced with Force=yes.
+	require.NoError(t, os.Symlink("other", a.link))
+	_, err := a.Execute(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "other", readlink(t, a.link))
+
+	a.force = true
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "target", readlink(t, a.link))
+
+	// the same applies to regular files.
+	require.NoError(t, os.Remove(a.link))
+	require.NoError(t, ioutil.WriteFile(a.link, []byte("file"), 0644))
+
+	a.force = false
+	_, err = a.Execute(context.Background())
+	assert.Error(t, err)
+
+	a.force = true
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "target", readlink(t, a.link))
+}
+
+func TestSymlinkDirectory(t *testing.T) {
+	a, _ := newAction(t, "target")
+	a.force = true
+
+	// directories are never replaced, not even with Force=yes.
+	require.NoError(t, os.Mkdir(a.link, 0755))
+	_, err := a.Execute(context.Background())
+	assert.Error(t, err)
+
+	stat, err := os.Lstat(a.link)
+	require.NoError(t, err)
+	assert.True(t, stat.IsDir())
+}
+
+func TestSymlinkCreateDirectories(t *testing.T) {
+	a, dir := newAction(t, "../target")
+	a.link = filepath.Join(dir, "sub/dir/link")
+
+	_, err := a.Execute(context.Background())
+	assert.Error(t, err)
+
+	a.createDirectories = true
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "../target", readlink(t, a.link))
+}
+
+func TestSymlinkAbsent(t *testing.T) {
+	a, _ := newAction(t, "")
+	a.state = stateAbsent
+
+	// nothing to do if the link does not exist.
+	changed, err := a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// only symbolic links are removed.
+	require.NoError(t, ioutil.WriteFile(a.link, []byte("file"), 0644))
+	_, err = a.Execute(context.Background())
+	assert.Error(t, err)
+	assert.FileExists(t, a.link)
+
+	require.NoError(t, os.Remove(a.link))
+	require.NoError(t, os.Symlink("target", a.link))
+	changed, err = a.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	_, err = os.Lstat(a.link)
+	assert.True(t, os.IsNotExist(err))
+}
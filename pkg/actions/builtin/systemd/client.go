@@ -12,7 +12,8 @@ import (
 
 // systemctl wraps the systemd systemctl command.
 type systemctl struct {
-	installDirectory string
+	installDirectory   string
+	preserveAttributes bool
 }
 
 func newClient(installDirectory string) (*systemctl, error) {
@@ -99,7 +100,12 @@ func (cli *systemctl) copyUnitFile(file string) (bool, error) {
 		return update, err
 	}
 
-	if err := utils.CopyAtomicKeepMode(file, targetFileName, 0600); err != nil {
+	opts := &utils.AtomicOptions{
+		DiscardOwner:  !cli.preserveAttributes,
+		DiscardXattrs: !cli.preserveAttributes,
+	}
+
+	if err := utils.CopyAtomicKeepMode(file, targetFileName, 0600, opts); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -46,6 +46,12 @@ func init() {
 				Type:        conf.StringType,
 				Default:     "/etc/systemd/system",
 			},
+			{
+				Name:        "PreserveAttributes",
+				Description: "If set to yes, the owner, group and extended attributes (including POSIX ACLs) of an already installed unit file are preserved when it is updated.",
+				Type:        conf.BoolType,
+				Default:     "yes",
+			},
 		},
 	})
 }
@@ -82,6 +88,7 @@ func setupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
 
 	a := &systemdAction{
 		installDirectory:    installDirectory,
+		preserveAttributes:  sec.GetBoolDefault("PreserveAttributes", true),
 		unitsToEnable:       enableUnits,
 		enableNow:           enableNow,
 		autoEnableInstalled: autoEnable,
@@ -98,6 +105,7 @@ type systemdAction struct {
 	autoEnableInstalled bool
 	enableNow           bool
 	installDirectory    string
+	preserveAttributes  bool
 
 	cli *systemctl
 }
@@ -109,6 +117,7 @@ func (a *systemdAction) Prepare(graph actions.ExecGraph) error {
 	if err != nil {
 		return err
 	}
+	cli.preserveAttributes = a.preserveAttributes
 
 	a.cli = cli
 	return nil
@@ -0,0 +1,245 @@
+package user
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils/passwd"
+)
+
+// Supported values for the State= option.
+const (
+	statePresent = "present"
+	stateAbsent  = "absent"
+)
+
+// accounts holds all account databases below a root directory.
+type accounts struct {
+	passwd  *passwd.Database
+	shadow  *passwd.Database
+	group   *passwd.Database
+	gshadow *passwd.Database
+	defs    passwd.LoginDefs
+	lock    *os.File
+}
+
+// loadAccounts locks and loads all account databases below root.
+// The lock must be released using close.
+func loadAccounts(root string) (_ *accounts, err error) {
+	acc := new(accounts)
+
+	path := func(p string) string {
+		return filepath.Join(root, p)
+	}
+
+	if acc.lock, err = passwd.Lock(path("/etc/.pwd.lock")); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			acc.close()
+		}
+	}()
+
+	if acc.passwd, err = passwd.Load(path("/etc/passwd"), passwd.PasswdFields); err != nil {
+		return nil, err
+	}
+
+	if acc.shadow, err = passwd.Load(path("/etc/shadow"), passwd.ShadowFields); err != nil {
+		return nil, err
+	}
+
+	if acc.group, err = passwd.Load(path("/etc/group"), passwd.GroupFields); err != nil {
+		return nil, err
+	}
+
+	if acc.gshadow, err = passwd.Load(path("/etc/gshadow"), passwd.GShadowFields); err != nil {
+		return nil, err
+	}
+
+	if acc.defs, err = passwd.LoadLoginDefs(path("/etc/login.defs")); err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+// close releases the lock taken by loadAccounts.
+func (acc *accounts) close() error {
+	return acc.lock.Close()
+}
+
+// save writes all modified databases. The shadow databases are only
+// written if they already exist.
+func (acc *accounts) save() (bool, error) {
+	var changed bool
+
+	for _, db := range []struct {
+		db   *passwd.Database
+		mode os.FileMode
+	}{
+		{acc.group, 0644},
+		{acc.gshadow, 0640},
+		{acc.passwd, 0644},
+		{acc.shadow, 0640},
+	} {
+		if db.db == acc.gshadow || db.db == acc.shadow {
+			if !db.db.Exists() {
+				continue
+			}
+		}
+
+		c, err := db.db.Save(db.mode)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update %s: %w", db.db.Path(), err)
+		}
+		changed = changed || c
+	}
+
+	return changed, nil
+}
+
+// lookupGroup returns the group entry for nameOrID. It returns nil
+// if no such group exists.
+func (acc *accounts) lookupGroup(nameOrID string) []string {
+	if entry := acc.group.Get(nameOrID); entry != nil {
+		return entry
+	}
+
+	if _, err := strconv.Atoi(nameOrID); err != nil {
+		return nil
+	}
+
+	for _, entry := range acc.group.Entries() {
+		if entry[2] == nameOrID {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// usedIDs returns a set of all IDs used in db. The ID is expected
+// in the third field.
+func usedIDs(db *passwd.Database) map[int]bool {
+	used := make(map[int]bool)
+
+	for _, entry := range db.Entries() {
+		if id, err := strconv.Atoi(entry[2]); err == nil {
+			used[id] = true
+		}
+	}
+
+	return used
+}
+
+// allocateID returns the first ID in [min, max] that is not used.
+func allocateID(used map[int]bool, min, max int) (int, error) {
+	for id := min; id <= max; id++ {
+		if !used[id] {
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free ID available in range %d-%d", min, max)
+}
+
+// updateMembers adds (or removes) user to the member list stored
+// in the field at idx of entry. It returns the updated entry and
+// true if the member list has been modified.
+func updateMembers(entry []string, idx int, user string, member bool) ([]string, bool) {
+	members := passwd.Members(entry[idx])
+
+	for i, m := range members {
+		if m != user {
+			continue
+		}
+
+		if member {
+			return entry, false
+		}
+
+		members = append(members[:i], members[i+1:]...)
+		entry[idx] = strings.Join(members, ",")
+		return entry, true
+	}
+
+	if !member {
+		return entry, false
+	}
+
+	entry[idx] = strings.Join(append(members, user), ",")
+	return entry, true
+}
+
+// getOptionalInt returns the value of the option name or nil if
+// it's not set.
+func getOptionalInt(sec conf.Section, name string) (*int, error) {
+	val, err := sec.GetInt(name)
+	if err != nil {
+		if conf.IsNotSet(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+
+	if val < 0 {
+		return nil, fmt.Errorf("invalid value for %s: %d", name, val)
+	}
+
+	i := int(val)
+	return &i, nil
+}
+
+// getOptionalString returns the value of the option name or nil
+// if it's not set.
+func getOptionalString(sec conf.Section, name string) (*string, error) {
+	val, err := sec.GetString(name)
+	if err != nil {
+		if conf.IsNotSet(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+
+	return &val, nil
+}
+
+// getCommonOptions returns the values for Name=, Root= and State=.
+func getCommonOptions(sec conf.Section) (name, root, state string, err error) {
+	name, err = sec.GetString("Name")
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if name == "" || strings.ContainsAny(name, ":,\n") {
+		return "", "", "", fmt.Errorf("invalid name %q", name)
+	}
+
+	root, err = sec.GetString("Root")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return "", "", "", err
+		}
+		root = "/"
+	}
+
+	state, err = sec.GetString("State")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return "", "", "", err
+		}
+		state = statePresent
+	}
+
+	if state != statePresent && state != stateAbsent {
+		return "", "", "", fmt.Errorf("invalid value for State: %q", state)
+	}
+
+	return name, root, state, nil
+}
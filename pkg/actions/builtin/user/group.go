@@ -0,0 +1,201 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "Group",
+		Description: "Create, update or remove local groups.",
+		Setup:       setupGroupAction,
+		Example:     groupExample,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"The `Group` action directly reads and updates `/etc/group` and `/etc/gshadow` (if it exists) below Root=. " +
+					"The files are only written if the group entry differs from the expected one. " +
+					"Like useradd(8) the action holds the lock on `/etc/.pwd.lock` while the databases are updated.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Name",
+				Description: "The name of the group.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name: "GID",
+				Description: "" +
+					"The numerical ID of the group. If unset, new groups get the next free ID assigned and the ID of existing groups is not changed. " +
+					"If the ID of an existing group is changed, users with this primary group are updated as well.",
+				Type: conf.IntType,
+			},
+			{
+				Name:        "System",
+				Description: "Whether or not a new group should be created as a system group. System groups use a different GID range.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "State",
+				Description: "Whether the group should be `present` or `absent`. Groups that are still the primary group of a user cannot be removed.",
+				Type:        conf.StringType,
+				Default:     statePresent,
+			},
+			{
+				Name:        "Root",
+				Description: "Manage the group below an alternate root directory.",
+				Type:        conf.StringType,
+				Default:     "/",
+			},
+		},
+	})
+}
+
+func setupGroupAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	name, root, state, err := getCommonOptions(sec)
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := getOptionalInt(sec, "GID")
+	if err != nil {
+		return nil, err
+	}
+
+	system, err := sec.GetBool("System")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for System: %w", err)
+	}
+
+	return &groupAction{
+		name:   name,
+		root:   root,
+		state:  state,
+		gid:    gid,
+		system: system,
+	}, nil
+}
+
+type groupAction struct {
+	actions.Base
+
+	name   string
+	root   string
+	state  string
+	gid    *int
+	system bool
+}
+
+func (ga *groupAction) Name() string {
+	return "Group " + ga.name
+}
+
+func (ga *groupAction) Execute(_ context.Context) (bool, error) {
+	acc, err := loadAccounts(ga.root)
+	if err != nil {
+		return false, err
+	}
+	defer acc.close()
+
+	if ga.state == stateAbsent {
+		if err := removeGroup(acc, ga.name); err != nil {
+			return false, err
+		}
+	} else {
+		if _, err := ensureGroup(acc, ga.name, ga.gid, ga.system); err != nil {
+			return false, err
+		}
+	}
+
+	return acc.save()
+}
+
+// ensureGroup makes sure the group name exists and returns it's GID.
+// If gid is nil, a new group gets the next free GID assigned.
+func ensureGroup(acc *accounts, name string, gid *int, system bool) (int, error) {
+	entry := acc.group.Get(name)
+
+	if entry == nil {
+		var id int
+
+		used := usedIDs(acc.group)
+		if gid != nil {
+			id = *gid
+			if used[id] {
+				return 0, fmt.Errorf("GID %d is already used", id)
+			}
+		} else {
+			var err error
+
+			min, max := acc.defs.GIDRange(system)
+			id, err = allocateID(used, min, max)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		entry = []string{name, "x", strconv.Itoa(id), ""}
+	} else if gid != nil && entry[2] != strconv.Itoa(*gid) {
+		if usedIDs(acc.group)[*gid] {
+			return 0, fmt.Errorf("GID %d is already used", *gid)
+		}
+
+		// users with name as their primary group must follow.
+		for _, user := range acc.passwd.Entries() {
+			if user[3] == entry[2] {
+				user[3] = strconv.Itoa(*gid)
+				acc.passwd.Set(user)
+			}
+		}
+
+		entry[2] = strconv.Itoa(*gid)
+	}
+
+	acc.group.Set(entry)
+
+	if acc.gshadow.Exists() && acc.gshadow.Get(name) == nil {
+		acc.gshadow.Set([]string{name, "!", "", ""})
+	}
+
+	return strconv.Atoi(entry[2])
+}
+
+// removeGroup removes the group name. It fails if name is still
+// the primary group of a user.
+func removeGroup(acc *accounts, name string) error {
+	entry := acc.group.Get(name)
+	if entry == nil {
+		return nil
+	}
+
+	for _, user := range acc.passwd.Entries() {
+		if user[3] == entry[2] {
+			return fmt.Errorf("cannot remove group %s: primary group of user %s", name, user[0])
+		}
+	}
+
+	acc.group.Remove(name)
+	acc.gshadow.Remove(name)
+
+	return nil
+}
+
+const groupExample = `[Task]
+Description= Create the docker group
+
+[Group]
+Name=docker
+System=yes
+`
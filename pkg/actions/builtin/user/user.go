@@ -0,0 +1,589 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	copyDir "github.com/otiai10/copy"
+
+	"github.com/khulnasoft-lab/system-conf/conf"
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/change"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+func init() {
+	actions.MustRegister(actions.Plugin{
+		Name:        "User",
+		Description: "Create, update or remove local user accounts.",
+		Setup:       setupUserAction,
+		Example:     userExample,
+		Author:      "Md Sulaiman <infosulaimanbd@gmail.com>",
+		Website:     "https://github.com/khulnasoft-lab/system-deploy",
+		Help: []actions.HelpSection{
+			{
+				Title: "Change Detection",
+				Description: "" +
+					"The `User` action directly reads and updates `/etc/passwd`, `/etc/shadow`, `/etc/group` and `/etc/gshadow` below Root=. " +
+					"The shadow files are only updated if they exist. Files are only written if an entry differs from the expected one so " +
+					"the task is reported as pristine if the user already matches the configuration. " +
+					"Options that are not set are not changed for existing users. " +
+					"Like useradd(8) the action holds the lock on `/etc/.pwd.lock` while the databases are updated.",
+			},
+			{
+				Title: "New Users",
+				Description: "" +
+					"If Group= is not set, new users get a group with the same name as the user assigned. The group is created if it does not exist yet. " +
+					"New users without PasswordHash= are created with a locked password. " +
+					"If the home directory is created, the content of `/etc/skel` (below Root=) is copied into it.",
+			},
+			{
+				Title: "Removing Users",
+				Description: "" +
+					"If State=absent, the user is removed from all databases and group member lists. The user group is removed as well " +
+					"if it has the same name as the user and no other members. The home directory is kept.",
+			},
+		},
+		Options: []conf.OptionSpec{
+			{
+				Name:        "Name",
+				Description: "The name of the user.",
+				Type:        conf.StringType,
+				Required:    true,
+			},
+			{
+				Name:        "UID",
+				Description: "The numerical ID of the user. If unset, new users get the next free ID assigned and the ID of existing users is not changed.",
+				Type:        conf.IntType,
+			},
+			{
+				Name:        "Group",
+				Description: "The primary group of the user (either name or ID). The group must already exist.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Groups",
+				Description: "A list of supplementary groups (either name or ID) for the user. The user is removed from all groups not listed unless AppendGroups=yes. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "AppendGroups",
+				Description: "If set, the user is only added to Groups= but never removed from other groups.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name:        "Comment",
+				Description: "The comment (GECOS) field of the user.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Shell",
+				Description: "The login shell of the user. Defaults to /bin/sh for new users.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "Home",
+				Description: "The home directory of the user. Defaults to /home/<Name> for new users.",
+				Type:        conf.StringType,
+			},
+			{
+				Name:        "CreateHome",
+				Description: "Whether or not the home directory should be created if it does not exist. Defaults to yes for regular and to no for system users.",
+				Type:        conf.BoolType,
+			},
+			{
+				Name:        "System",
+				Description: "Whether or not a new user should be created as a system user. System users use a different UID range.",
+				Type:        conf.BoolType,
+				Default:     "no",
+			},
+			{
+				Name: "PasswordHash",
+				Description: "" +
+					"The password hash of the user as stored in /etc/shadow (see crypt(5)). Use an empty value for a password-less login or ! to lock the password. " +
+					"Like all options the value is subject to environment variable substitution so every `$` of the hash must be written as `$$`, " +
+					"for example `$$6$$salt$$hash`.",
+				Type: conf.StringType,
+			},
+			{
+				Name:        "AuthorizedKeys",
+				Description: "SSH public keys for ~/.ssh/authorized_keys. If set, the file is fully managed and only contains the listed keys. May be specified multiple times.",
+				Type:        conf.StringSliceType,
+			},
+			{
+				Name:        "State",
+				Description: "Whether the user should be `present` or `absent`.",
+				Type:        conf.StringType,
+				Default:     statePresent,
+			},
+			{
+				Name:        "Root",
+				Description: "Manage the user below an alternate root directory.",
+				Type:        conf.StringType,
+				Default:     "/",
+			},
+		},
+	})
+}
+
+func setupUserAction(task deploy.Task, sec conf.Section) (actions.Action, error) {
+	name, root, state, err := getCommonOptions(sec)
+	if err != nil {
+		return nil, err
+	}
+
+	ua := &userAction{
+		name:           name,
+		root:           root,
+		state:          state,
+		groups:         getList(sec, "Groups"),
+		authorizedKeys: sec.GetStringSlice("AuthorizedKeys"),
+	}
+
+	if ua.uid, err = getOptionalInt(sec, "UID"); err != nil {
+		return nil, err
+	}
+
+	if ua.group, err = getOptionalString(sec, "Group"); err != nil {
+		return nil, err
+	}
+
+	if ua.comment, err = getOptionalString(sec, "Comment"); err != nil {
+		return nil, err
+	}
+
+	if ua.shell, err = getOptionalString(sec, "Shell"); err != nil {
+		return nil, err
+	}
+
+	if ua.home, err = getOptionalString(sec, "Home"); err != nil {
+		return nil, err
+	}
+
+	if ua.passwordHash, err = getOptionalString(sec, "PasswordHash"); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range []*string{ua.group, ua.comment, ua.shell, ua.home, ua.passwordHash} {
+		if opt != nil && strings.ContainsAny(*opt, ":\n") {
+			return nil, fmt.Errorf("invalid value %q", *opt)
+		}
+	}
+
+	ua.system, err = sec.GetBool("System")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for System: %w", err)
+	}
+
+	ua.createHome, err = sec.GetBool("CreateHome")
+	if err != nil {
+		if !conf.IsNotSet(err) {
+			return nil, fmt.Errorf("invalid value for CreateHome: %w", err)
+		}
+		ua.createHome = !ua.system
+	}
+
+	ua.appendGroups, err = sec.GetBool("AppendGroups")
+	if err != nil && !conf.IsNotSet(err) {
+		return nil, fmt.Errorf("invalid value for AppendGroups: %w", err)
+	}
+
+	// Groups= might be specified with an empty value to remove
+	// the user from all groups.
+	ua.manageGroups = len(sec.GetStringSlice("Groups")) > 0
+
+	return ua, nil
+}
+
+// getList returns all values of the option name split at
+// whitespace or commas.
+func getList(sec conf.Section, name string) []string {
+	var result []string
+
+	for _, val := range sec.GetStringSlice(name) {
+		result = append(result, strings.FieldsFunc(val, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})...)
+	}
+
+	return result
+}
+
+type userAction struct {
+	actions.Base
+
+	name           string
+	root           string
+	state          string
+	uid            *int
+	group          *string
+	groups         []string
+	manageGroups   bool
+	appendGroups   bool
+	comment        *string
+	shell          *string
+	home           *string
+	createHome     bool
+	system         bool
+	passwordHash   *string
+	authorizedKeys []string
+}
+
+func (ua *userAction) Name() string {
+	return "User " + ua.name
+}
+
+func (ua *userAction) Execute(_ context.Context) (bool, error) {
+	acc, err := loadAccounts(ua.root)
+	if err != nil {
+		return false, err
+	}
+	defer acc.close()
+
+	if ua.state == stateAbsent {
+		ua.remove(acc)
+		return acc.save()
+	}
+
+	entry, err := ua.ensure(acc)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := acc.save()
+	if err != nil {
+		return changed, err
+	}
+
+	uid, _ := strconv.Atoi(entry[2])
+	gid, _ := strconv.Atoi(entry[3])
+	home := filepath.Join(ua.root, entry[5])
+
+	if ua.createHome {
+		created, err := ua.ensureHome(home, uid, gid)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || created
+	}
+
+	if ua.authorizedKeys != nil {
+		updated, err := ua.ensureAuthorizedKeys(home, uid, gid)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || updated
+	}
+
+	return changed, nil
+}
+
+// ensure updates the account databases so the user matches the
+// configuration and returns the user's passwd entry.
+func (ua *userAction) ensure(acc *accounts) ([]string, error) {
+	entry := acc.passwd.Get(ua.name)
+	isNew := entry == nil
+
+	if isNew {
+		entry = []string{ua.name, "x", "", "", "", "/home/" + ua.name, "/bin/sh"}
+	}
+
+	// resolve the UID
+	if ua.uid != nil {
+		id := strconv.Itoa(*ua.uid)
+		if id != entry[2] {
+			for _, other := range acc.passwd.Entries() {
+				if other[2] == id && other[0] != ua.name {
+					return nil, fmt.Errorf("UID %s is already used by %s", id, other[0])
+				}
+			}
+		}
+		entry[2] = id
+	} else if isNew {
+		min, max := acc.defs.UIDRange(ua.system)
+		id, err := allocateID(usedIDs(acc.passwd), min, max)
+		if err != nil {
+			return nil, err
+		}
+		entry[2] = strconv.Itoa(id)
+	}
+
+	// resolve the primary group
+	if ua.group != nil {
+		grp := acc.lookupGroup(*ua.group)
+		if grp == nil {
+			return nil, fmt.Errorf("group %q does not exist", *ua.group)
+		}
+		entry[3] = grp[2]
+	} else if isNew {
+		// create a user group with the same name and try to use
+		// the same ID as the user.
+		var gid *int
+		if grp := acc.group.Get(ua.name); grp == nil {
+			if uid, _ := strconv.Atoi(entry[2]); !usedIDs(acc.group)[uid] {
+				gid = &uid
+			}
+		}
+
+		id, err := ensureGroup(acc, ua.name, gid, ua.system)
+		if err != nil {
+			return nil, err
+		}
+		entry[3] = strconv.Itoa(id)
+	}
+
+	if ua.comment != nil {
+		entry[4] = *ua.comment
+	}
+	if ua.home != nil {
+		entry[5] = *ua.home
+	}
+	if ua.shell != nil {
+		entry[6] = *ua.shell
+	}
+
+	if err := ua.ensurePassword(acc, entry, isNew); err != nil {
+		return nil, err
+	}
+
+	if ua.manageGroups {
+		if err := ua.ensureGroups(acc); err != nil {
+			return nil, err
+		}
+	}
+
+	if acc.passwd.Set(entry) {
+		if isNew {
+			ua.Infof("creating user %s with UID %s", ua.name, entry[2])
+		} else {
+			ua.Infof("updating user %s", ua.name)
+		}
+	}
+
+	return entry, nil
+}
+
+// ensurePassword updates the password hash either in /etc/shadow
+// or, if it doesn't exist, in /etc/passwd.
+func (ua *userAction) ensurePassword(acc *accounts, entry []string, isNew bool) error {
+	hash := "!"
+	if ua.passwordHash != nil {
+		hash = *ua.passwordHash
+	}
+
+	if !acc.shadow.Exists() {
+		if isNew || ua.passwordHash != nil {
+			entry[1] = hash
+		}
+		return nil
+	}
+
+	if entry[1] != "x" {
+		// the password has been stored in /etc/passwd, move it
+		// to /etc/shadow.
+		if ua.passwordHash == nil && !isNew {
+			hash = entry[1]
+		}
+		entry[1] = "x"
+	}
+
+	lastChange := strconv.FormatInt(time.Now().Unix()/86400, 10)
+
+	shadow := acc.shadow.Get(ua.name)
+	if shadow == nil {
+		acc.shadow.Set([]string{ua.name, hash, lastChange, "0", "99999", "7", "", "", ""})
+		return nil
+	}
+
+	if ua.passwordHash != nil && shadow[1] != hash {
+		shadow[1] = hash
+		shadow[2] = lastChange
+		acc.shadow.Set(shadow)
+	}
+
+	return nil
+}
+
+// ensureGroups updates the member lists of all groups so the user
+// is a member of exactly the groups listed in Groups=.
+func (ua *userAction) ensureGroups(acc *accounts) error {
+	wanted := make(map[string]bool)
+
+	for _, g := range ua.groups {
+		grp := acc.lookupGroup(g)
+		if grp == nil {
+			return fmt.Errorf("group %q does not exist", g)
+		}
+		wanted[grp[0]] = true
+	}
+
+	for _, grp := range acc.group.Entries() {
+		member := wanted[grp[0]]
+		if !member && ua.appendGroups {
+			continue
+		}
+
+		if updated, ok := updateMembers(grp, 3, ua.name, member); ok {
+			acc.group.Set(updated)
+		}
+
+		if gshadow := acc.gshadow.Get(grp[0]); gshadow != nil {
+			if updated, ok := updateMembers(gshadow, 3, ua.name, member); ok {
+				acc.gshadow.Set(updated)
+			}
+		}
+	}
+
+	return nil
+}
+
+// remove removes the user from all account databases.
+func (ua *userAction) remove(acc *accounts) {
+	entry := acc.passwd.Get(ua.name)
+
+	acc.passwd.Remove(ua.name)
+	acc.shadow.Remove(ua.name)
+
+	for _, grp := range acc.group.Entries() {
+		if updated, ok := updateMembers(grp, 3, ua.name, false); ok {
+			acc.group.Set(updated)
+		}
+	}
+
+	for _, grp := range acc.gshadow.Entries() {
+		if updated, ok := updateMembers(grp, 3, ua.name, false); ok {
+			acc.gshadow.Set(updated)
+		}
+	}
+
+	if entry == nil {
+		return
+	}
+
+	ua.Infof("removing user %s", ua.name)
+
+	// remove the user group if it's no longer used.
+	grp := acc.group.Get(ua.name)
+	if grp == nil || grp[2] != entry[3] || grp[3] != "" {
+		return
+	}
+
+	for _, other := range acc.passwd.Entries() {
+		if other[3] == grp[2] {
+			return
+		}
+	}
+
+	acc.group.Remove(ua.name)
+	acc.gshadow.Remove(ua.name)
+}
+
+// ensureHome creates the home directory of the user if it does
+// not exist.
+func (ua *userAction) ensureHome(home string, uid, gid int) (bool, error) {
+	if _, err := os.Lstat(home); err == nil || !os.IsNotExist(err) {
+		return false, err
+	}
+
+	ua.Infof("creating home directory %s", home)
+
+	if err := os.MkdirAll(filepath.Dir(home), 0755); err != nil {
+		return false, err
+	}
+
+	skel := filepath.Join(ua.root, "/etc/skel")
+	if _, err := os.Stat(skel); err == nil {
+		if err := copyDir.Copy(skel, home); err != nil {
+			return false, fmt.Errorf("failed to copy %s: %w", skel, err)
+		}
+	} else if err := os.Mkdir(home, 0700); err != nil {
+		return false, err
+	}
+
+	if err := os.Chmod(home, 0700); err != nil {
+		return false, err
+	}
+
+	err := filepath.Walk(home, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+
+	return true, err
+}
+
+// ensureAuthorizedKeys makes sure ~/.ssh/authorized_keys contains
+// exactly the configured keys.
+func (ua *userAction) ensureAuthorizedKeys(home string, uid, gid int) (bool, error) {
+	var changed bool
+
+	// ~/.ssh and authorized_keys are controlled by the user. Never
+	// follow symlinks as we are going to write and chown them.
+	sshDir := filepath.Join(home, ".ssh")
+	info, err := os.Lstat(sshDir)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Mkdir(sshDir, 0700); err != nil {
+			return false, err
+		}
+		changed = true
+	case err != nil:
+		return false, err
+	case !info.IsDir():
+		return false, fmt.Errorf("refusing to use %s: not a directory", sshDir)
+	}
+
+	if updated, err := change.EnsureFileOwner(sshDir, uid, gid); err != nil {
+		return changed, err
+	} else if updated {
+		changed = true
+	}
+
+	content := strings.Join(ua.authorizedKeys, "\n") + "\n"
+	keyFile := filepath.Join(sshDir, "authorized_keys")
+
+	if info, err := os.Lstat(keyFile); err == nil && !info.Mode().IsRegular() {
+		return changed, fmt.Errorf("refusing to update %s: not a regular file", keyFile)
+	} else if err != nil && !os.IsNotExist(err) {
+		return changed, err
+	}
+
+	updated, err := utils.UpdateAtomic(keyFile, 0600, []byte(content))
+	if err != nil {
+		return changed, err
+	}
+	changed = changed || updated
+
+	if updated, err := change.EnsureFileMode(keyFile, 0600); err != nil {
+		return changed, err
+	} else if updated {
+		changed = true
+	}
+
+	if updated, err := change.EnsureFileOwner(keyFile, uid, gid); err != nil {
+		return changed, err
+	} else if updated {
+		changed = true
+	}
+
+	return changed, nil
+}
+
+const userExample = `[Task]
+Description= Create the user bar
+
+[User]
+Name=bar
+Shell=/bin/zsh
+Groups=sudo docker
+AuthorizedKeys=ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB7ZtzbpeRKDJM6hLyGcnXhDhr+Um9FaUKkwmCXqZc7Q bar@example.com
+`
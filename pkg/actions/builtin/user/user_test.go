@@ -0,0 +1,205 @@
+package user
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/actions"
+	"github.com/khulnasoft-lab/system-deploy/pkg/deploy"
+)
+
+// newTestRoot creates a root directory with minimal account
+// databases.
+func newTestRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "user")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	files := map[string]string{
+		"/etc/passwd":  "root:x:0:0:root:/root:/bin/sh\n",
+		"/etc/shadow":  "root:*:18000:0:99999:7:::\n",
+		"/etc/group":   "root:x:0:\nwheel:x:10:root\n",
+		"/etc/gshadow": "root:::\nwheel:::root\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	return root
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// intPtr returns a pointer to i.
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of the home directory requires root")
+	}
+
+	root := newTestRoot(t)
+
+	ua := &userAction{
+		Base:           actions.Base{Logger: actions.NewLogger()},
+		name:           "bar",
+		root:           root,
+		state:          statePresent,
+		uid:            intPtr(1000),
+		groups:         []string{"wheel"},
+		manageGroups:   true,
+		createHome:     true,
+		authorizedKeys: []string{"ssh-ed25519 AAAA bar@example.com"},
+	}
+
+	changed, err := ua.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/passwd")), "bar:x:1000:1000::/home/bar:/bin/sh\n")
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/shadow")), "bar:!:")
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/group")), "wheel:x:10:root,bar\n")
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/gshadow")), "wheel:::root,bar\n")
+	assert.Equal(t, "ssh-ed25519 AAAA bar@example.com\n", readFile(t, filepath.Join(root, "/home/bar/.ssh/authorized_keys")))
+
+	// a second run must not change anything.
+	changed, err = ua.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	ua.state = stateAbsent
+	changed, err = ua.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	assert.Equal(t, "root:x:0:0:root:/root:/bin/sh\n", readFile(t, filepath.Join(root, "/etc/passwd")))
+	assert.Equal(t, "root:x:0:\nwheel:x:10:root\n", readFile(t, filepath.Join(root, "/etc/group")))
+}
+
+func TestUserAuthorizedKeysSymlink(t *testing.T) {
+	for _, link := range []string{".ssh", ".ssh/authorized_keys"} {
+		root := newTestRoot(t)
+
+		// the target must never be modified.
+		target := filepath.Join(root, "root", ".ssh")
+		linkTarget := filepath.Join(root, "root", link)
+		require.NoError(t, os.MkdirAll(target, 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(target, "authorized_keys"), []byte("root key\n"), 0600))
+
+		home := filepath.Join(root, "home", "bar")
+		require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0700))
+		path := filepath.Join(home, link)
+		require.NoError(t, os.RemoveAll(path))
+		require.NoError(t, os.Symlink(linkTarget, path))
+
+		ua := &userAction{
+			Base:           actions.Base{Logger: actions.NewLogger()},
+			name:           "bar",
+			root:           root,
+			state:          statePresent,
+			uid:            intPtr(1000),
+			authorizedKeys: []string{"ssh-ed25519 AAAA bar@example.com"},
+		}
+
+		_, err := ua.Execute(context.Background())
+		assert.Error(t, err, link)
+		assert.Equal(t, "root key\n", readFile(t, filepath.Join(target, "authorized_keys")), link)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	root := newTestRoot(t)
+
+	ga := &groupAction{
+		Base:  actions.Base{Logger: actions.NewLogger()},
+		name:  "docker",
+		root:  root,
+		state: statePresent,
+		gid:   intPtr(998),
+	}
+
+	changed, err := ga.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/group")), "docker:x:998:\n")
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/gshadow")), "docker:!::\n")
+
+	changed, err = ga.Execute(context.Background())
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// GIDs must not be used twice.
+	ga.name = "other"
+	_, err = ga.Execute(context.Background())
+	assert.Error(t, err)
+
+	ga.name = "docker"
+	ga.state = stateAbsent
+	changed, err = ga.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "root:x:0:\nwheel:x:10:root\n", readFile(t, filepath.Join(root, "/etc/group")))
+
+	// users follow the GID of their primary group.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "/etc/passwd"), []byte("root:x:0:0:root:/root:/bin/sh\nbar:x:1000:998::/home/bar:/bin/sh\n"), 0644))
+	ga.state = statePresent
+	_, err = ga.Execute(context.Background())
+	require.NoError(t, err)
+
+	ga.gid = intPtr(1001)
+	changed, err = ga.Execute(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/group")), "docker:x:1001:\n")
+	assert.Contains(t, readFile(t, filepath.Join(root, "/etc/passwd")), "bar:x:1000:1001::/home/bar:/bin/sh\n")
+
+	// primary groups cannot be removed.
+	ga.name = "root"
+	ga.gid = nil
+	ga.state = stateAbsent
+	_, err = ga.Execute(context.Background())
+	assert.Error(t, err)
+}
+
+// setupTask parses content as a task file and sets up the action of
+// its first section.
+func setupTask(t *testing.T, content string) (actions.Action, error) {
+	tsk, err := deploy.Decode("test.task", strings.NewReader(content))
+	require.NoError(t, err)
+
+	if err := deploy.ApplyEnvironment(tsk); err != nil {
+		return nil, err
+	}
+
+	sec := tsk.Sections[0]
+	return actions.Setup(sec.Name, actions.NewLogger(), *tsk, sec)
+}
+
+func TestUserPasswordHashTask(t *testing.T) {
+	act, err := setupTask(t, "[User]\nName=bar\nPasswordHash=$$6$$rounds=5000$$salt$$hash\n")
+	require.NoError(t, err)
+
+	ua := act.(*userAction)
+	require.NotNil(t, ua.passwordHash)
+	assert.Equal(t, "$6$rounds=5000$salt$hash", *ua.passwordHash)
+
+	// without escaping $6 is substituted.
+	_, err = setupTask(t, "[User]\nName=bar\nPasswordHash=$6$salt$hash\n")
+	assert.Error(t, err)
+}
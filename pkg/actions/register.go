@@ -86,7 +86,7 @@ func Setup(name string, log Logger, task deploy.Task, section conf.Section) (Act
 	}
 
 	// prepare the section by applying defaults and validating all options.
-	prepared, err := conf.Prepare(section, plg.Options)
+	prepared, err := conf.Prepare(section, conf.SectionSpec(plg.Options))
 	if err != nil {
 		return nil, err
 	}
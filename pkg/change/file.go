@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
 	"github.com/twmb/murmur3"
 )
 
@@ -87,3 +88,24 @@ func EnsureFileMode(path string, mode os.FileMode) (bool, error) {
 	}
 	return true, nil
 }
+
+// EnsureFileOwner ensures that path is owned by uid and gid. A
+// value of -1 for either uid or gid leaves it unchanged. It
+// returns true if the ownership has been updated, false
+// otherwise. Symbolic links are not followed.
+func EnsureFileOwner(path string, uid, gid int) (bool, error) {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+
+	curUID, curGID := utils.FileOwner(stat)
+	if (uid == -1 || uid == curUID) && (gid == -1 || gid == curGID) {
+		return false, nil
+	}
+
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return false, err
+	}
+	return true, nil
+}
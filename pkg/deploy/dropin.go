@@ -5,9 +5,21 @@ import "github.com/khulnasoft-lab/system-conf/conf"
 // ApplyDropIns applies all dropins on t. It basically wraps conf.ApplyDropIns and re-creates
 // the tasks meta-data.
 func ApplyDropIns(t *Task, dropins []*conf.DropIn, spec map[string]map[string]conf.OptionSpec) error {
-	if err := conf.ApplyDropIns(t.file, dropins, spec); err != nil {
+	if err := conf.ApplyDropIns(t.file, dropins, shimSpec(spec)); err != nil {
 		return err
 	}
 
 	return applyMetaData(t)
 }
+
+func shimSpec(m map[string]map[string]conf.OptionSpec) conf.FileSpec {
+	fs := conf.FileSpec{}
+	for k, v := range m {
+		var s conf.SectionSpec
+		for _, o := range v {
+			s = append(s, o)
+		}
+		fs[k] = s
+	}
+	return fs
+}
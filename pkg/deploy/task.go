@@ -130,7 +130,7 @@ func decodeMetaData(section conf.Section, task *Task) error {
 		specs[idx] = spec.OptionSpec
 	}
 
-	return conf.ValidateOptions(section.Options, specs)
+	return conf.ValidateOptions(section.Options, conf.SectionSpec(specs))
 }
 
 // Clone creates a deep copy of t.
@@ -101,7 +101,8 @@ var taskOptions = []taskMetaOption{
 		OptionSpec: conf.OptionSpec{
 			Name: "Environment",
 			Description: "Configure one or more environment files that are loaded into the task and may be used during substitution. " +
-				"Environment files are loaded in the order they are specified and later ones overwrite already existing values.",
+				"Environment files are loaded in the order they are specified and later ones overwrite already existing values. " +
+				"Referencing a variable that is not set is an error. Use `$$` for a literal `$` in option values.",
 			Type: conf.StringSliceType,
 		},
 		set: func(val conf.Options, t *Task) error {
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/google/renameio"
@@ -13,19 +15,112 @@ import (
 // dest will never be a zero-length file. It will always either contain
 // the previous data (or not exist) or the new data but never anything
 // in between.
+//
+// If dest already exists, it's owner, group and extended attributes
+// (including POSIX ACLs) are preserved. The owner and group are only
+// preserved if the caller is permitted to change them. See
+// AtomicOptions for how to disable this.
 func CreateAtomic(dest string, fileMode os.FileMode, r io.Reader) error {
+	return CreateAtomicOwner(dest, fileMode, -1, -1, r, nil)
+}
+
+// AtomicOptions holds additional options for CreateAtomicOwner
+// and CopyAtomicOwner.
+type AtomicOptions struct {
+	// Validate, if set, is called with the path of the staged
+	// temporary file before it is renamed to dest. If Validate
+	// returns an error, dest is left untouched.
+	Validate func(path string) error
+
+	// DiscardOwner disables preserving the owner and group of an
+	// existing destination file.
+	DiscardOwner bool
+
+	// DiscardXattrs disables preserving the extended attributes
+	// (including POSIX ACLs) of an existing destination file.
+	DiscardXattrs bool
+
+	// ExplicitMode must be set if the file mode passed to
+	// CreateAtomicOwner has been configured explicitly. The POSIX
+	// access ACL of an existing destination file is not preserved
+	// in this case because its mask would override the group bits
+	// of the requested mode.
+	ExplicitMode bool
+}
+
+// CreateAtomicOwner is like CreateAtomic but also changes the owner
+// of the new file to uid and gid before it is renamed to dest. A value
+// of -1 for either uid or gid keeps the owner or group of an existing
+// dest. If neither uid nor gid is set, keeping the owner is best
+// effort so unprivileged users can still replace files owned by
+// someone else. opts may be nil.
+func CreateAtomicOwner(dest string, fileMode os.FileMode, uid, gid int, r io.Reader, opts *AtomicOptions) error {
+	if opts == nil {
+		opts = new(AtomicOptions)
+	}
+
+	explicitOwner := uid != -1 || gid != -1
+
+	// only attributes of regular files are preserved. If dest is a
+	// symbolic link it's going to be replaced by a regular file.
+	current, err := os.Lstat(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	keep := err == nil && current.Mode().IsRegular()
+
+	if keep && !opts.DiscardOwner {
+		curUID, curGID := FileOwner(current)
+		if uid == -1 {
+			uid = curUID
+		}
+		if gid == -1 {
+			gid = curGID
+		}
+	}
+
 	tmpFile, err := renameio.TempFile("", dest)
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer tmpFile.Cleanup() //nolint:errcheck
 
+	// the content is written first because writing clears
+	// security.capability as well as the setuid and setgid bits.
+	if _, err := io.Copy(tmpFile, r); err != nil {
+		return fmt.Errorf("failed to copy source file: %w", err)
+	}
+
+	// change the owner before the mode as chown may clear the
+	// setuid and setgid bits.
+	if uid != -1 || gid != -1 {
+		if err := tmpFile.Chown(uid, gid); err != nil && (explicitOwner || !os.IsPermission(err)) {
+			return fmt.Errorf("failed to update owner of temp file: %w", err)
+		}
+	}
+
 	if err := tmpFile.Chmod(fileMode); err != nil {
 		return fmt.Errorf("failed to update mode bits of temp file: %w", err)
 	}
 
-	if _, err := io.Copy(tmpFile, r); err != nil {
-		return fmt.Errorf("failed to copy source file: %w", err)
+	// extended attributes are copied last because POSIX ACLs would
+	// otherwise be modified by chmod and security.capability
+	// cleared by writes and chown.
+	if keep && !opts.DiscardXattrs {
+		var skip []string
+		if opts.ExplicitMode {
+			skip = append(skip, aclAccessXattr)
+		}
+
+		if err := copyXattrs(dest, tmpFile.Name(), skip...); err != nil {
+			return err
+		}
+	}
+
+	if opts.Validate != nil {
+		if err := opts.Validate(tmpFile.Name()); err != nil {
+			return fmt.Errorf("validation of %q failed: %w", dest, err)
+		}
 	}
 
 	if err := tmpFile.CloseAtomicallyReplace(); err != nil {
@@ -46,10 +141,22 @@ func CopyAtomicMode(src, dst string, mode os.FileMode) error {
 	return CreateAtomic(dst, mode, f)
 }
 
+// CopyAtomicOwner is like CopyAtomicMode but also changes the owner
+// of dst to uid and gid. See CreateAtomicOwner.
+func CopyAtomicOwner(src, dst string, mode os.FileMode, uid, gid int, opts *AtomicOptions) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return CreateAtomicOwner(dst, mode, uid, gid, f, opts)
+}
+
 // CopyAtomicKeepMode is like CopyAtomicMode by tries to keep the
 // mode bits of dst if it exists. If dst does not yet exist the
-// mode bits are set to defaultMode.
-func CopyAtomicKeepMode(src, dst string, defaultMode os.FileMode) error {
+// mode bits are set to defaultMode. opts may be nil.
+func CopyAtomicKeepMode(src, dst string, defaultMode os.FileMode, opts *AtomicOptions) error {
 	mode := defaultMode
 	dstStat, err := os.Lstat(dst)
 	if err != nil {
@@ -60,5 +167,25 @@ func CopyAtomicKeepMode(src, dst string, defaultMode os.FileMode) error {
 		mode = dstStat.Mode()
 	}
 
-	return CopyAtomicMode(src, dst, mode)
+	return CopyAtomicOwner(src, dst, mode, -1, -1, opts)
+}
+
+// UpdateAtomic is like CreateAtomic but only replaces dest if it's
+// content differs from data. It returns true if dest has been created
+// or updated.
+func UpdateAtomic(dest string, fileMode os.FileMode, data []byte) (bool, error) {
+	current, err := ioutil.ReadFile(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+
+	if err := CreateAtomic(dest, fileMode, bytes.NewReader(data)); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 
 	"github.com/flynn/go-shlex"
@@ -16,7 +18,12 @@ type ExecOptions struct {
 	PipeOutput bool
 	PipeInput  bool
 	Env        map[string]string
+	CleanEnv   bool
 	ExitCode   *int64
+	Sandbox    *Sandbox
+	// Output, if set, receives a copy of everything the command
+	// writes to stdout and stderr, even if PipeOutput is set.
+	Output io.Writer
 }
 
 type ExitCodeError struct {
@@ -29,17 +36,30 @@ func (ece *ExitCodeError) Error() string {
 }
 
 // ExecCommand executes cmd and returns any error encountered.
+// cmd is split into arguments using shell like syntax but it's not
+// executed by a shell.
 func ExecCommand(ctx context.Context, workDir string, cmd string, opts *ExecOptions) error {
 	parts, err := shlex.Split(cmd)
 	if err != nil {
 		return err
 	}
 
-	if len(parts) < 1 {
+	return ExecArgs(ctx, workDir, parts, opts)
+}
+
+// ExecArgs is like ExecCommand but executes args[0] with the
+// remaining arguments as they are.
+func ExecArgs(ctx context.Context, workDir string, args []string, opts *ExecOptions) error {
+	if len(args) < 1 {
 		return fmt.Errorf("invalid command")
 	}
 
-	c := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	name, argv := args[0], args[1:]
+	if opts != nil && opts.Sandbox != nil && opts.Sandbox.needsHelper() {
+		name, argv = sandboxHelper, nil
+	}
+
+	c := exec.CommandContext(ctx, name, argv...)
 
 	if workDir != "" {
 		c.Dir = workDir
@@ -59,15 +79,38 @@ func ExecCommand(ctx context.Context, workDir string, cmd string, opts *ExecOpti
 			c.Stdout = os.Stdout
 		}
 
+		if opts.Output != nil {
+			// stdout and stderr are copied by different goroutines
+			// if they are not the same writer.
+			output := &lockedWriter{w: opts.Output}
+			if c.Stdout == c.Stderr {
+				w := io.MultiWriter(c.Stdout, output)
+				c.Stdout, c.Stderr = w, w
+			} else {
+				c.Stdout = io.MultiWriter(c.Stdout, output)
+				c.Stderr = io.MultiWriter(c.Stderr, output)
+			}
+		}
+
 		if opts.PipeInput {
 			c.Stdin = os.Stdin
 		}
 
+		if opts.CleanEnv {
+			c.Env = []string{}
+		}
+
 		if opts.Env != nil {
 			for k, v := range opts.Env {
 				c.Env = append(c.Env, fmt.Sprintf("%s=%s", k, v))
 			}
 		}
+
+		if opts.Sandbox != nil {
+			if err := opts.Sandbox.prepare(c, args); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err := c.Run(); err != nil {
@@ -89,3 +132,16 @@ func ExecCommand(ctx context.Context, workDir string, cmd string, opts *ExecOpti
 
 	return nil
 }
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.w.Write(p)
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecArgsOutput(t *testing.T) {
+	for _, pipe := range []bool{false, true} {
+		var output bytes.Buffer
+
+		err := ExecArgs(context.Background(), "", []string{"/bin/sh", "-c", "echo out; echo err >&2"}, &ExecOptions{
+			PipeOutput: pipe,
+			Output:     &output,
+		})
+		require.NoError(t, err)
+
+		assert.Contains(t, output.String(), "out\n")
+		assert.Contains(t, output.String(), "err\n")
+	}
+}
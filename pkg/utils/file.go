@@ -1,6 +1,9 @@
 package utils
 
-import "os"
+import (
+	"os"
+	"syscall"
+)
 
 // FileMode returns the file mode of path.
 func FileMode(path string) (os.FileMode, error) {
@@ -11,3 +14,15 @@ func FileMode(path string) (os.FileMode, error) {
 
 	return stat.Mode(), nil
 }
+
+// FileOwner returns the user and group ID of the file described
+// by info. If the owner cannot be determined -1 is returned for
+// both.
+func FileOwner(info os.FileInfo) (uid, gid int) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+
+	return int(stat.Uid), int(stat.Gid)
+}
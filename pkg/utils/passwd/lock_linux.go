@@ -0,0 +1,40 @@
+package passwd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockTimeout is the time Lock waits for the lock like lckpwdf(3).
+const lockTimeout = 15 * time.Second
+
+// Lock takes the lock that is used by lckpwdf(3) and tools like
+// useradd(8) and passwd(1) to serialize changes to the account
+// databases. path is usually /etc/.pwd.lock. The lock is released
+// when the returned file is closed.
+func Lock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &unix.Flock_t{Type: unix.F_WRLCK}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := unix.FcntlFlock(f.Fd(), unix.F_SETLK, lock)
+		if err == nil {
+			return f, nil
+		}
+
+		if (err != unix.EAGAIN && err != unix.EACCES) || time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package passwd
+
+import "os"
+
+// Lock only creates the lock file on platforms other than Linux.
+func Lock(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+}
@@ -0,0 +1,70 @@
+package passwd
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoginDefs holds the configuration from /etc/login.defs.
+type LoginDefs map[string]string
+
+// LoadLoginDefs loads the login.defs file at path. A missing
+// file is not treated as an error.
+func LoadLoginDefs(path string) (LoginDefs, error) {
+	defs := make(LoginDefs)
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defs, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		defs[fields[0]] = fields[1]
+	}
+
+	return defs, scanner.Err()
+}
+
+// Int returns the integer value of key or def if key is not
+// set or invalid.
+func (defs LoginDefs) Int(key string, def int) int {
+	val, ok := defs[key]
+	if !ok {
+		return def
+	}
+
+	i, err := strconv.ParseInt(val, 0, 64)
+	if err != nil {
+		return def
+	}
+
+	return int(i)
+}
+
+// UIDRange returns the range of user IDs to use for new users.
+func (defs LoginDefs) UIDRange(system bool) (min, max int) {
+	if system {
+		return defs.Int("SYS_UID_MIN", 100), defs.Int("SYS_UID_MAX", 999)
+	}
+	return defs.Int("UID_MIN", 1000), defs.Int("UID_MAX", 60000)
+}
+
+// GIDRange returns the range of group IDs to use for new groups.
+func (defs LoginDefs) GIDRange(system bool) (min, max int) {
+	if system {
+		return defs.Int("SYS_GID_MIN", 100), defs.Int("SYS_GID_MAX", 999)
+	}
+	return defs.Int("GID_MIN", 1000), defs.Int("GID_MAX", 60000)
+}
@@ -0,0 +1,230 @@
+// Package passwd implements reading and writing of colon separated
+// account databases like /etc/passwd, /etc/group, /etc/shadow and
+// /etc/gshadow.
+package passwd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/khulnasoft-lab/system-deploy/pkg/utils"
+)
+
+// Number of fields for the supported database files.
+const (
+	PasswdFields  = 7
+	GroupFields   = 4
+	ShadowFields  = 9
+	GShadowFields = 4
+)
+
+// Database is a colon separated account database. The first field
+// of each entry is used as the key. Lines that cannot be parsed
+// as entries (like comments) are kept as they are.
+type Database struct {
+	path   string
+	fields int
+	lines  []line
+	exists bool
+}
+
+type line struct {
+	raw    string
+	fields []string
+}
+
+// Load loads the database at path. Each entry is expected to have
+// numFields fields. Entries with less fields are padded. If path
+// does not exist an empty database is returned.
+func Load(path string, numFields int) (*Database, error) {
+	db := &Database{
+		path:   path,
+		fields: numFields,
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	db.exists = true
+	if err := db.parse(f); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Parse is like Load but reads the database from r.
+func Parse(r io.Reader, numFields int) (*Database, error) {
+	db := &Database{
+		fields: numFields,
+	}
+
+	if err := db.parse(r); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *Database) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		if text == "" || strings.HasPrefix(text, "#") || !strings.Contains(text, ":") {
+			db.lines = append(db.lines, line{raw: text})
+			continue
+		}
+
+		db.lines = append(db.lines, line{fields: db.pad(strings.Split(text, ":"))})
+	}
+
+	return scanner.Err()
+}
+
+func (db *Database) pad(fields []string) []string {
+	for len(fields) < db.fields {
+		fields = append(fields, "")
+	}
+	return fields
+}
+
+// Exists returns true if the database file existed when
+// it was loaded.
+func (db *Database) Exists() bool {
+	return db.exists
+}
+
+// Path returns the path of the database.
+func (db *Database) Path() string {
+	return db.path
+}
+
+// Get returns a copy of the entry with the given name or nil
+// if no such entry exists.
+func (db *Database) Get(name string) []string {
+	for _, l := range db.lines {
+		if l.fields != nil && l.fields[0] == name {
+			return append([]string(nil), l.fields...)
+		}
+	}
+
+	return nil
+}
+
+// Entries returns a copy of all entries in the database.
+func (db *Database) Entries() [][]string {
+	var result [][]string
+
+	for _, l := range db.lines {
+		if l.fields != nil {
+			result = append(result, append([]string(nil), l.fields...))
+		}
+	}
+
+	return result
+}
+
+// Set adds or replaces the entry identified by the first
+// element of fields. It returns true if the database
+// has been modified.
+func (db *Database) Set(fields []string) bool {
+	fields = db.pad(append([]string(nil), fields...))
+
+	for idx, l := range db.lines {
+		if l.fields == nil || l.fields[0] != fields[0] {
+			continue
+		}
+
+		if equal(l.fields, fields) {
+			return false
+		}
+
+		db.lines[idx].fields = fields
+		return true
+	}
+
+	db.lines = append(db.lines, line{fields: fields})
+	return true
+}
+
+// Remove removes the entry with the given name. It returns true
+// if the database has been modified.
+func (db *Database) Remove(name string) bool {
+	for idx, l := range db.lines {
+		if l.fields != nil && l.fields[0] == name {
+			db.lines = append(db.lines[:idx], db.lines[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bytes returns the serialized database.
+func (db *Database) Bytes() []byte {
+	var buf bytes.Buffer
+
+	for _, l := range db.lines {
+		if l.fields == nil {
+			buf.WriteString(l.raw)
+		} else {
+			buf.WriteString(strings.Join(l.fields, ":"))
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.Bytes()
+}
+
+// Save writes the database back to it's path if it has been
+// modified. The mode bits, ownership and extended attributes of
+// an existing file are kept. defaultMode is used if the file did
+// not exist. Save returns true if the file has been written.
+func (db *Database) Save(defaultMode os.FileMode) (bool, error) {
+	mode := defaultMode
+
+	stat, err := os.Stat(db.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	} else {
+		mode = stat.Mode()
+	}
+
+	return utils.UpdateAtomic(db.path, mode, db.Bytes())
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Members splits a comma separated member list as used in
+// /etc/group and /etc/gshadow.
+func Members(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
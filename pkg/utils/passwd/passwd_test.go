@@ -0,0 +1,29 @@
+package passwd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase(t *testing.T) {
+	input := "# a comment\nroot:x:0:0:root:/root:/bin/bash\nbin:x:1:1::/:/usr/bin/nologin\n"
+
+	db, err := Parse(strings.NewReader(input), PasswdFields)
+	require.NoError(t, err)
+
+	assert.Equal(t, input, string(db.Bytes()))
+	assert.Equal(t, []string{"bin", "x", "1", "1", "", "/", "/usr/bin/nologin"}, db.Get("bin"))
+	assert.Nil(t, db.Get("foo"))
+
+	assert.False(t, db.Set([]string{"root", "x", "0", "0", "root", "/root", "/bin/bash"}))
+	assert.True(t, db.Set([]string{"root", "x", "0", "0", "root", "/root", "/bin/zsh"}))
+	assert.True(t, db.Set([]string{"foo", "x", "1000", "1000"}))
+	assert.True(t, db.Remove("bin"))
+	assert.False(t, db.Remove("bin"))
+
+	assert.Equal(t, "# a comment\nroot:x:0:0:root:/root:/bin/zsh\nfoo:x:1000:1000:::\n", string(db.Bytes()))
+	assert.Len(t, db.Entries(), 2)
+}
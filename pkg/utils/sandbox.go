@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sandbox holds additional restrictions for commands executed
+// using ExecCommand or ExecArgs. All restrictions are applied by
+// re-executing the current binary as a small helper right before the
+// actual command is executed. Binaries that use Sandbox must call
+// SandboxInit at the very beginning of main.
+type Sandbox struct {
+	// UMask is the file mode creation mask of the command.
+	UMask *int
+
+	// Nice is the scheduling priority of the command.
+	Nice *int
+
+	// Rlimits holds resource limits for the command.
+	Rlimits []Rlimit
+
+	// PrivateNetwork executes the command in a new network
+	// namespace with only a loopback device. The helper brings
+	// the loopback device up.
+	PrivateNetwork bool
+
+	// PrivateTmp mounts new tmpfs file systems on /tmp and
+	// /var/tmp in a new mount namespace.
+	PrivateTmp bool
+
+	// NoNewPrivileges ensures the command and its children can
+	// never gain new privileges, for example using setuid binaries.
+	NoNewPrivileges bool
+
+	// CapabilityBoundingSet, if not nil, holds the names of all
+	// capabilities that are kept in the bounding set. All others
+	// are dropped.
+	CapabilityBoundingSet []string
+}
+
+// Rlimit is a resource limit.
+type Rlimit struct {
+	// Resource is the name of the resource like NOFILE.
+	Resource string
+	Soft     uint64
+	Hard     uint64
+}
+
+// RlimitResources holds the names of all supported resources.
+var RlimitResources = []string{"AS", "CORE", "CPU", "DATA", "FSIZE", "MEMLOCK", "NOFILE", "NPROC", "STACK"}
+
+// capabilityNames holds all known capabilities ordered by their
+// number.
+var capabilityNames = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// ParseRlimit parses a resource limit for resource in the form
+// "soft:hard" or "limit" (which sets both). Limits may be
+// "infinity".
+func ParseRlimit(resource, value string) (Rlimit, error) {
+	known := false
+	for _, r := range RlimitResources {
+		if r == resource {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return Rlimit{}, fmt.Errorf("unsupported resource %q", resource)
+	}
+
+	soft, hard := value, value
+	if idx := strings.IndexByte(value, ':'); idx >= 0 {
+		soft, hard = value[:idx], value[idx+1:]
+	}
+
+	limit := Rlimit{Resource: resource}
+
+	var err error
+	if limit.Soft, err = parseLimit(soft); err != nil {
+		return Rlimit{}, err
+	}
+	if limit.Hard, err = parseLimit(hard); err != nil {
+		return Rlimit{}, err
+	}
+
+	if limit.Soft > limit.Hard {
+		return Rlimit{}, fmt.Errorf("soft limit %s is greater than hard limit %s", soft, hard)
+	}
+
+	return limit, nil
+}
+
+func parseLimit(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if value == "infinity" {
+		return math.MaxUint64, nil
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// ParseCapabilities parses a list of capability names like
+// CapabilityBoundingSet= of systemd. If the first name starts with
+// "~" the list is inverted and contains all capabilities that should
+// be dropped. It returns the names of all capabilities to keep.
+func ParseCapabilities(names []string) ([]string, error) {
+	invert := len(names) > 0 && strings.HasPrefix(names[0], "~")
+
+	listed := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimPrefix(name, "~"))
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+
+		if capabilityNumber(name) < 0 {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		listed[name] = true
+	}
+
+	keep := []string{}
+	for _, name := range capabilityNames {
+		if listed[name] != invert {
+			keep = append(keep, name)
+		}
+	}
+
+	return keep, nil
+}
+
+// capabilityNumber returns the number of the capability name or -1.
+func capabilityNumber(name string) int {
+	for idx, n := range capabilityNames {
+		if n == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// needsHelper returns true if the helper process is required to
+// apply the sandbox.
+func (s *Sandbox) needsHelper() bool {
+	return s.UMask != nil ||
+		s.Nice != nil ||
+		s.PrivateNetwork ||
+		len(s.Rlimits) > 0 ||
+		s.PrivateTmp ||
+		s.NoNewPrivileges ||
+		s.CapabilityBoundingSet != nil
+}
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxEnv is the environment variable that passes the sandbox
+// configuration to the helper process.
+const sandboxEnv = "SYSTEM_DEPLOY_SANDBOX"
+
+// sandboxHelper is the path used to re-execute the current binary.
+const sandboxHelper = "/proc/self/exe"
+
+// sandboxConfig is passed to the helper process.
+type sandboxConfig struct {
+	Sandbox
+	// Path is the resolved path of Args[0]. It is resolved before
+	// the helper is executed because the environment of the helper
+	// may not contain PATH.
+	Path   string
+	Args   []string
+	Dir    string
+	Chroot string
+	Cred   *syscall.Credential
+}
+
+var rlimitResources = map[string]int{
+	"AS":      unix.RLIMIT_AS,
+	"CORE":    unix.RLIMIT_CORE,
+	"CPU":     unix.RLIMIT_CPU,
+	"DATA":    unix.RLIMIT_DATA,
+	"FSIZE":   unix.RLIMIT_FSIZE,
+	"MEMLOCK": unix.RLIMIT_MEMLOCK,
+	"NOFILE":  unix.RLIMIT_NOFILE,
+	"NPROC":   unix.RLIMIT_NPROC,
+	"STACK":   unix.RLIMIT_STACK,
+}
+
+// prepare updates c, which must have been created for sandboxHelper
+// if s.needsHelper() is true, to execute args within the sandbox.
+// The chroot, credentials and working directory of c are moved to
+// the helper because they must be applied after all other
+// restrictions.
+func (s *Sandbox) prepare(c *exec.Cmd, args []string) error {
+	var attrs syscall.SysProcAttr
+	if c.SysProcAttr != nil {
+		attrs = *c.SysProcAttr
+	}
+
+	if s.PrivateNetwork {
+		attrs.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	if !s.needsHelper() {
+		c.SysProcAttr = &attrs
+		return nil
+	}
+
+	if s.PrivateTmp {
+		attrs.Unshareflags |= syscall.CLONE_NEWNS
+	}
+
+	// like exec.Command, resolve bare command names using the PATH
+	// of the current process. Other paths may be relative to the
+	// working directory of the command.
+	var path string
+	if !strings.Contains(args[0], "/") {
+		var err error
+		if path, err = exec.LookPath(args[0]); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(sandboxConfig{
+		Sandbox: *s,
+		Path:    path,
+		Args:    args,
+		Dir:     c.Dir,
+		Chroot:  attrs.Chroot,
+		Cred:    attrs.Credential,
+	})
+	if err != nil {
+		return err
+	}
+
+	attrs.Chroot = ""
+	attrs.Credential = nil
+	c.Dir = ""
+
+	c.Args[0] = "system-deploy-sandbox"
+	c.Env = append(c.Env, sandboxEnv+"="+string(data))
+	c.SysProcAttr = &attrs
+
+	return nil
+}
+
+// SandboxInit must be called at the very beginning of main by all
+// binaries that execute commands using a Sandbox. If the current
+// process has been started as the sandbox helper it applies the
+// sandbox and replaces itself with the actual command. Otherwise it
+// does nothing.
+func SandboxInit() {
+	data, ok := os.LookupEnv(sandboxEnv)
+	if !ok {
+		return
+	}
+
+	// credentials, the nice value and the capability bounding set
+	// are per thread so everything must happen on the thread that
+	// finally executes the command.
+	runtime.LockOSThread()
+
+	err := runSandbox(data)
+	fmt.Fprintf(os.Stderr, "system-deploy: failed to set up sandbox: %s\n", err)
+	os.Exit(127)
+}
+
+func runSandbox(data string) error {
+	var cfg sandboxConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return err
+	}
+
+	if err := os.Unsetenv(sandboxEnv); err != nil {
+		return err
+	}
+
+	if cfg.PrivateNetwork {
+		if err := loopbackUp(); err != nil {
+			return err
+		}
+	}
+
+	for _, limit := range cfg.Rlimits {
+		resource, ok := rlimitResources[limit.Resource]
+		if !ok {
+			return fmt.Errorf("unsupported resource %q", limit.Resource)
+		}
+
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: limit.Soft, Max: limit.Hard}); err != nil {
+			return fmt.Errorf("failed to set limit for %s: %w", limit.Resource, err)
+		}
+	}
+
+	if cfg.Nice != nil {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, *cfg.Nice); err != nil {
+			return fmt.Errorf("failed to set nice value: %w", err)
+		}
+	}
+
+	if cfg.UMask != nil {
+		unix.Umask(*cfg.UMask)
+	}
+
+	// /proc may not be available after chroot.
+	lastCap := lastCapability()
+
+	if cfg.Chroot != "" {
+		if err := unix.Chroot(cfg.Chroot); err != nil {
+			return fmt.Errorf("failed to chroot: %w", err)
+		}
+
+		if cfg.Dir == "" {
+			cfg.Dir = "/"
+		}
+	}
+
+	if cfg.PrivateTmp {
+		if err := mountPrivateTmp(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Dir != "" {
+		if err := unix.Chdir(cfg.Dir); err != nil {
+			return fmt.Errorf("failed to change working directory: %w", err)
+		}
+	}
+
+	if cfg.CapabilityBoundingSet != nil {
+		if err := dropCapabilities(cfg.CapabilityBoundingSet, lastCap); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Cred != nil {
+		if err := setCredential(cfg.Cred); err != nil {
+			return err
+		}
+	}
+
+	if cfg.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("failed to set no_new_privs: %w", err)
+		}
+	}
+
+	if len(cfg.Args) == 0 {
+		return fmt.Errorf("no command")
+	}
+
+	path := cfg.Path
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath(cfg.Args[0]); err != nil {
+			return err
+		}
+	}
+
+	return unix.Exec(path, cfg.Args, os.Environ())
+}
+
+// loopbackUp brings up the loopback device which is down in a new
+// network namespace.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to bring up loopback device: %w", err)
+	}
+	defer unix.Close(fd)
+
+	// struct ifreq with ifr_flags.
+	var ifr struct {
+		name  [unix.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("failed to bring up loopback device: %w", errno)
+	}
+
+	ifr.flags |= unix.IFF_UP
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("failed to bring up loopback device: %w", errno)
+	}
+
+	return nil
+}
+
+// mountPrivateTmp mounts new tmpfs file systems on /tmp and
+// /var/tmp. The helper already runs in a new mount namespace.
+func mountPrivateTmp() error {
+	for _, dir := range []string{"/tmp", "/var/tmp"} {
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			continue
+		}
+
+		if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("failed to mount %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// lastCapability returns the number of the last capability
+// supported by the kernel.
+func lastCapability() int {
+	data, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		if last, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return last
+		}
+	}
+
+	return len(capabilityNames) - 1
+}
+
+// dropCapabilities drops all capabilities that are not listed in
+// keep from the bounding set.
+func dropCapabilities(keep []string, lastCap int) error {
+	kept := make(map[int]bool)
+	for _, name := range keep {
+		kept[capabilityNumber(name)] = true
+	}
+
+	for cap := 0; cap <= lastCap; cap++ {
+		if kept[cap] {
+			continue
+		}
+
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(cap), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return fmt.Errorf("failed to drop capability %d: %w", cap, err)
+		}
+	}
+
+	return nil
+}
+
+// setCredential changes the user and group of the current thread
+// like syscall.SysProcAttr.Credential does.
+func setCredential(cred *syscall.Credential) error {
+	if !cred.NoSetGroups {
+		groups := make([]int, len(cred.Groups))
+		for idx, gid := range cred.Groups {
+			groups[idx] = int(gid)
+		}
+
+		if err := unix.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to set supplementary groups: %w", err)
+		}
+	}
+
+	if err := unix.Setresgid(int(cred.Gid), int(cred.Gid), int(cred.Gid)); err != nil {
+		return fmt.Errorf("failed to set group: %w", err)
+	}
+
+	if err := unix.Setresuid(int(cred.Uid), int(cred.Uid), int(cred.Uid)); err != nil {
+		return fmt.Errorf("failed to set user: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// sandboxHelper is not used on platforms other than Linux.
+const sandboxHelper = ""
+
+// prepare fails on platforms other than Linux.
+func (s *Sandbox) prepare(c *exec.Cmd, args []string) error {
+	return errors.New("sandboxing is not supported")
+}
+
+// SandboxInit is a no-op on platforms other than Linux.
+func SandboxInit() {}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRlimit(t *testing.T) {
+	limit, err := ParseRlimit("NOFILE", "1024:infinity")
+	require.NoError(t, err)
+	assert.Equal(t, Rlimit{Resource: "NOFILE", Soft: 1024, Hard: math.MaxUint64}, limit)
+
+	limit, err = ParseRlimit("NPROC", "100")
+	require.NoError(t, err)
+	assert.Equal(t, Rlimit{Resource: "NPROC", Soft: 100, Hard: 100}, limit)
+
+	_, err = ParseRlimit("NOFILE", "2048:1024")
+	assert.Error(t, err)
+
+	_, err = ParseRlimit("UNKNOWN", "1")
+	assert.Error(t, err)
+}
+
+func TestParseCapabilities(t *testing.T) {
+	keep, err := ParseCapabilities([]string{"CAP_CHOWN", "kill"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CAP_CHOWN", "CAP_KILL"}, keep)
+
+	keep, err = ParseCapabilities([]string{"~CAP_SYS_ADMIN"})
+	require.NoError(t, err)
+	assert.Len(t, keep, len(capabilityNames)-1)
+	assert.NotContains(t, keep, "CAP_SYS_ADMIN")
+
+	_, err = ParseCapabilities([]string{"CAP_UNKNOWN"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// LookupUID returns the numeric ID of the user nameOrID which
+// may either be a user name or a user ID.
+func LookupUID(nameOrID string) (int, error) {
+	u, err := user.Lookup(nameOrID)
+	if err != nil {
+		u, err = user.LookupId(nameOrID)
+	}
+
+	if err != nil {
+		// numeric IDs don't need to exist in the user database.
+		if id, err := strconv.ParseUint(nameOrID, 10, 32); err == nil {
+			return int(id), nil
+		}
+		return -1, fmt.Errorf("user %q does not exist", nameOrID)
+	}
+
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return -1, err
+	}
+
+	return int(id), nil
+}
+
+// LookupGID returns the numeric ID of the group nameOrID which
+// may either be a group name or a group ID.
+func LookupGID(nameOrID string) (int, error) {
+	grp, err := user.LookupGroup(nameOrID)
+	if err != nil {
+		grp, err = user.LookupGroupId(nameOrID)
+	}
+
+	if err != nil {
+		// numeric IDs don't need to exist in the group database.
+		if id, err := strconv.ParseUint(nameOrID, 10, 32); err == nil {
+			return int(id), nil
+		}
+		return -1, fmt.Errorf("group %q does not exist", nameOrID)
+	}
+
+	id, err := strconv.ParseUint(grp.Gid, 10, 32)
+	if err != nil {
+		return -1, err
+	}
+
+	return int(id), nil
+}
+
+// LookupOwner resolves the user and group name (or ID) to their
+// numeric IDs. Empty names are returned as -1.
+func LookupOwner(userName, groupName string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+
+	if userName != "" {
+		if uid, err = LookupUID(userName); err != nil {
+			return -1, -1, err
+		}
+	}
+
+	if groupName != "" {
+		if gid, err = LookupGID(groupName); err != nil {
+			return -1, -1, err
+		}
+	}
+
+	return uid, gid, nil
+}
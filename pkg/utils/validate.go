@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/flynn/go-shlex"
+)
+
+// Validator validates a file by executing a command like
+// `sshd -t -f %s` where %s is replaced by the path of the file.
+type Validator struct {
+	args []string
+}
+
+// NewValidator returns a new validator for cmd. cmd must contain
+// at least one %s which is replaced by the path of the file to
+// validate.
+func NewValidator(cmd string) (*Validator, error) {
+	args, err := shlex.Split(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) < 1 {
+		return nil, fmt.Errorf("invalid command")
+	}
+
+	var hasPath bool
+	for _, arg := range args {
+		if strings.Contains(arg, "%s") {
+			hasPath = true
+			break
+		}
+	}
+
+	if !hasPath {
+		return nil, fmt.Errorf("%q does not contain %%s", cmd)
+	}
+
+	return &Validator{args: args}, nil
+}
+
+// Validate executes the validation command for path. If the command
+// fails the returned error contains the output of the command.
+func (v *Validator) Validate(ctx context.Context, path string) error {
+	args := make([]string, len(v.args))
+	for idx, arg := range v.args {
+		args[idx] = strings.ReplaceAll(arg, "%s", path)
+	}
+
+	var output bytes.Buffer
+
+	c := exec.CommandContext(ctx, args[0], args[1:]...)
+	c.Stdout = &output
+	c.Stderr = &output
+
+	if err := c.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("%s: %w\n%s", strings.Join(args, " "), err, out)
+		}
+		return fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// Func returns a function suitable for AtomicOptions.Validate. It
+// is safe to call Func on a nil validator in which case nil is
+// returned.
+func (v *Validator) Func(ctx context.Context) func(path string) error {
+	if v == nil {
+		return nil
+	}
+
+	return func(path string) error {
+		return v.Validate(ctx, path)
+	}
+}
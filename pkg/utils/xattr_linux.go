@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+)
+
+// aclAccessXattr is the name of the extended attribute that stores
+// the POSIX access ACL.
+const aclAccessXattr = "system.posix_acl_access"
+
+// copyXattrs copies all extended attributes (including POSIX ACLs
+// which are stored as system.posix_acl_* attributes) from src
+// to dst. Attributes listed in skip are not copied. Symbolic links
+// are followed.
+func copyXattrs(src, dst string, skip ...string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if err == syscall.ENOTSUP {
+			return nil
+		}
+		return fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+
+outer:
+	for _, name := range names {
+		for _, s := range skip {
+			if s == name {
+				continue outer
+			}
+		}
+
+		value, err := getXattr(src, name)
+		if err != nil {
+			if err == syscall.ENODATA {
+				continue
+			}
+			return fmt.Errorf("failed to get extended attribute %s: %w", name, err)
+		}
+
+		if err := syscall.Setxattr(dst, name, value, 0); err != nil {
+			return fmt.Errorf("failed to set extended attribute %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	buf, err := readXattrBuffer(func(dest []byte) (int, error) {
+		return syscall.Listxattr(path, dest)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range bytes.Split(buf, []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	return readXattrBuffer(func(dest []byte) (int, error) {
+		return syscall.Getxattr(path, name, dest)
+	})
+}
+
+// readXattrBuffer calls fn first to determine the required buffer
+// size and then to actually read the data. It retries if the
+// data grew in between.
+func readXattrBuffer(fn func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := fn(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, size)
+		n, err := fn(buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	}
+}
+
+// GetXattr returns the value of the extended attribute name of
+// path.
+func GetXattr(path, name string) ([]byte, error) {
+	return getXattr(path, name)
+}
+
+// SetXattr sets the extended attribute name of path to value.
+func SetXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+// RemoveXattr removes the extended attribute name of path. It does
+// not fail if the attribute does not exist.
+func RemoveXattr(path, name string) error {
+	if err := syscall.Removexattr(path, name); err != nil && err != syscall.ENODATA {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testACL returns a POSIX access ACL granting rwx to the user with
+// ID 1000 and a rwx mask.
+func testACL() []byte {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.LittleEndian, uint32(2)) //nolint:errcheck
+	for _, e := range []struct {
+		tag, perm uint16
+		id        uint32
+	}{
+		{0x01, 6, 0xffffffff}, // user::rw-
+		{0x02, 7, 1000},       // user:1000:rwx
+		{0x04, 4, 0xffffffff}, // group::r--
+		{0x10, 7, 0xffffffff}, // mask::rwx
+		{0x20, 4, 0xffffffff}, // other::r--
+	} {
+		binary.Write(&buf, binary.LittleEndian, e) //nolint:errcheck
+	}
+
+	return buf.Bytes()
+}
+
+func TestCreateAtomicOwnerACL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xattr")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(dest, []byte("old"), 0644))
+
+	if err := SetXattr(dest, aclAccessXattr, testACL()); err != nil {
+		t.Skipf("POSIX ACLs not supported: %s", err)
+	}
+
+	// the ACL is preserved and its mask overrides the group bits.
+	require.NoError(t, CreateAtomicOwner(dest, 0640, -1, -1, strings.NewReader("new"), nil))
+
+	stat, err := os.Stat(dest)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0674), stat.Mode().Perm())
+
+	// an explicit mode wins over the ACL.
+	opts := &AtomicOptions{ExplicitMode: true}
+	require.NoError(t, CreateAtomicOwner(dest, 0640, -1, -1, strings.NewReader("new"), opts))
+
+	stat, err = os.Stat(dest)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm())
+
+	_, err = GetXattr(dest, aclAccessXattr)
+	assert.Equal(t, syscall.ENODATA, err)
+}
+
+func TestCreateAtomicOwnerCapability(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xattr")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(dest, []byte("old"), 0755))
+
+	// VFS_CAP_REVISION_2 with CAP_NET_BIND_SERVICE permitted and
+	// effective.
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{0x02000001, 1 << 10, 0, 0, 0}) //nolint:errcheck
+
+	if err := SetXattr(dest, "security.capability", buf.Bytes()); err != nil {
+		t.Skipf("file capabilities not supported: %s", err)
+	}
+
+	require.NoError(t, CreateAtomicOwner(dest, 0755, -1, -1, strings.NewReader("new"), nil))
+
+	value, err := GetXattr(dest, "security.capability")
+	require.NoError(t, err)
+	assert.Equal(t, buf.Bytes(), value)
+}
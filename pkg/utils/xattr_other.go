@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import "errors"
+
+var errXattrNotSupported = errors.New("extended attributes are not supported")
+
+const aclAccessXattr = "system.posix_acl_access"
+
+// copyXattrs is a no-op on platforms other than Linux.
+func copyXattrs(src, dst string, skip ...string) error {
+	return nil
+}
+
+// GetXattr is not supported on platforms other than Linux.
+func GetXattr(path, name string) ([]byte, error) {
+	return nil, errXattrNotSupported
+}
+
+// SetXattr is not supported on platforms other than Linux.
+func SetXattr(path, name string, value []byte) error {
+	return errXattrNotSupported
+}
+
+// RemoveXattr is not supported on platforms other than Linux.
+func RemoveXattr(path, name string) error {
+	return errXattrNotSupported
+}